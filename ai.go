@@ -0,0 +1,196 @@
+package main
+
+import (
+	"math/rand"
+)
+
+const (
+	// bot difficulty levels
+	botEasy    = "easy"
+	botMedium  = "medium"
+	botPerfect = "perfect"
+
+	// how many moves ahead the medium bot looks; it wins when it can and blocks what it sees coming
+	mediumSearchDepth = 2
+	// how many moves ahead the perfect bot looks on boards too big to search to the end
+	largeBoardSearchDepth = 3
+	// how many moves ahead the perfect bot looks in games without a search of their own
+	genericSearchDepth = 6
+	// numerical tic-tac-toe offers several numbers for every cell, so the perfect bot looks less far ahead
+	numericalSearchDepth = 4
+	// score of a won game before subtracting the moves it took
+	winScore = 1000
+)
+
+var botLevels = []string{botEasy, botMedium, botPerfect}
+
+// chooseBotMove returns the move id the bot plays with mark on a board where it is mark's turn. Boards that
+// pick bot moves themselves are asked to; the moves of other games are searched a few moves ahead
+func chooseBotMove(b gameBoard, mark, level string, rnd *rand.Rand) string {
+	if m, ok := b.(botMover); ok {
+		return m.BotMove(mark, level, rnd)
+	}
+	return searchMove(b, mark, level, genericSearchDepth, rnd)
+}
+
+// searchMove returns the best move for mark found by searching the legal moves of the board. The perfect bot
+// looks depth moves ahead
+func searchMove(b gameBoard, mark, level string, depth int, rnd *rand.Rand) string {
+	moves := b.LegalMoves()
+	rnd.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+	if level == botEasy {
+		return moves[0]
+	}
+	if level == botMedium {
+		depth = mediumSearchDepth
+	}
+
+	best, bestScore := moves[0], -1<<31
+	for _, moveID := range moves {
+		next := b.Clone()
+		logError(next.Play(mark, moveID))
+		score := searchBoard(next, depth-1, -1<<31+1, 1<<31-1)
+		if next.Turn() != mark {
+			score = -score
+		}
+		if score > bestScore {
+			best, bestScore = moveID, score
+		}
+	}
+	return best
+}
+
+// searchBoard is negamax over any game; it scores the board for the player whose turn it is. Quicker wins
+// score higher and slower losses score lower
+func searchBoard(b gameBoard, depth, alpha, beta int) int {
+	turn := b.Turn()
+	winner, over := b.Result()
+	if over {
+		if winner == markNone {
+			return 0
+		}
+		score := winScore - b.Moves()
+		if winner != turn {
+			score = -score
+		}
+		return score
+	}
+	if depth <= 0 {
+		return 0
+	}
+	best := -1<<31 + 1
+	for _, moveID := range b.LegalMoves() {
+		next := b.Clone()
+		logError(next.Play(turn, moveID))
+		var score int
+		if next.Turn() == turn {
+			score = searchBoard(next, depth-1, alpha, beta)
+		} else {
+			score = -searchBoard(next, depth-1, -beta, -alpha)
+		}
+		if score > best {
+			best = score
+		}
+		if best > alpha {
+			alpha = best
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best
+}
+
+// chooseMove returns the position the bot plays with mark on a board where it is mark's turn
+func chooseMove(b *board, mark, level string, rnd *rand.Rand) position {
+	free := b.free()
+	switch level {
+	case botEasy:
+		return free[rnd.Intn(len(free))]
+	case botMedium:
+		return bestMove(b, mark, mediumSearchDepth, rnd)
+	default:
+		if b.size > defaultBoardSize {
+			return bestMove(b, mark, largeBoardSearchDepth, rnd)
+		}
+		return bestMove(b, mark, len(free), rnd)
+	}
+}
+
+// candidates returns the empty cells worth searching. On the classic board that is every empty cell; on bigger
+// boards only cells next to a mark are considered, or the centre on an empty board
+func (b *board) candidates() []position {
+	if b.size <= defaultBoardSize {
+		return b.free()
+	}
+	if b.moves == 0 {
+		return []position{{row: b.size / 2, col: b.size / 2}}
+	}
+	positions := make([]position, 0)
+	for _, pos := range b.free() {
+		if b.nearMark(pos) {
+			positions = append(positions, pos)
+		}
+	}
+	return positions
+}
+
+// nearMark reports whether any of the cells around pos holds a mark
+func (b *board) nearMark(pos position) bool {
+	for row := pos.row - 1; row <= pos.row+1; row++ {
+		for col := pos.col - 1; col <= pos.col+1; col++ {
+			if b.inside(row, col) && b.cells[row][col] != markNone {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bestMove searches depth moves ahead and returns the best position for mark; ties are broken at random
+func bestMove(b *board, mark string, depth int, rnd *rand.Rand) position {
+	free := b.candidates()
+	rnd.Shuffle(len(free), func(i, j int) { free[i], free[j] = free[j], free[i] })
+
+	best, bestScore := free[0], -1<<31
+	for _, pos := range free {
+		next := b.clone()
+		next.apply(mark, pos, mark)
+		score := -negamax(next, opponentMark(mark), depth-1, -1<<31+1, 1<<31-1)
+		if score > bestScore {
+			best, bestScore = pos, score
+		}
+	}
+	return best
+}
+
+// negamax scores the board for mark, whose turn it is. Quicker wins score higher and slower losses score lower
+func negamax(b *board, mark string, depth, alpha, beta int) int {
+	if b.winner != markNone {
+		// the previous move decided the game; in misère games it was lost by whoever made it
+		score := b.size*b.size + 1 - b.moves
+		if b.winner != mark {
+			score = -score
+		}
+		return score
+	}
+	if b.over() || depth <= 0 {
+		return 0
+	}
+	best := -1<<31 + 1
+	for _, pos := range b.candidates() {
+		next := b.clone()
+		next.apply(mark, pos, mark)
+		score := -negamax(next, opponentMark(mark), depth-1, -beta, -alpha)
+		if score > best {
+			best = score
+		}
+		if best > alpha {
+			alpha = best
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best
+}
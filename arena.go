@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// points in an arena; a player who has won the last two games or more scores double until it fails to win
+	arenaPointsWin       = 2.0
+	arenaPointsDraw      = 1.0
+	arenaStreakForDouble = 2
+	// extra point for winning a game after going berserk
+	arenaBerserkBonus = 1.0
+	// how long a player who went berserk has for each move
+	berserkMoveTime = 10 * time.Second
+
+	// how long a node may hold the right to pair the players of an arena
+	arenaPairingLockTTL = 5 * time.Second
+)
+
+// getTournamentStreaksKey returns the hash of players of an arena to the number of games they have won in a row
+func getTournamentStreaksKey(id string) string {
+	return "hash:tournament:" + id + ":streaks"
+}
+
+// getArenaOpponentsKey returns the hash of players of an arena to the opponent of their last game
+func getArenaOpponentsKey(id string) string {
+	return "hash:tournament:" + id + ":opponents"
+}
+
+// getArenaFinishedKey returns the hash of the finished pairings of an arena's round keyed by their number. They
+// are kept apart from the round's pairings so that the nodes driving the arena only read the ones still going
+func getArenaFinishedKey(id string, round int) string {
+	return getTournamentRoundKey(id, round) + ":finished"
+}
+
+func getArenaLockKey(id string) string {
+	return "lock:tournament:" + id + ":pairing"
+}
+
+// releaseLockScript deletes a lock if this node still holds it
+//
+// KEYS: lock key
+// ARGV: node token
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// PairArena pairs the free players of an arena with each other, players on similar points first, and ends the
+// arena once its time is up and the last games are over
+func (g *game) PairArena(t *tournament, over bool) (bool, error) {
+	if time.Now().Unix() >= t.EndsAt {
+		if !over {
+			return false, nil
+		}
+		return finishTournament(g.redisClient, t, "")
+	}
+
+	// one node pairs at a time so that nobody is paired twice
+	token, err := newSessionToken()
+	if err != nil {
+		return false, err
+	}
+	key := getArenaLockKey(t.ID)
+	ok, err := g.redisClient.SetNX(key, token, arenaPairingLockTTL).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to lock arena pairing")
+	}
+	if !ok {
+		return false, nil
+	}
+	defer func() {
+		logError(releaseLockScript.Run(g.redisClient, []string{key}, token).Err())
+	}()
+
+	// read the pairings again now that nobody else can add to them
+	pairings, _, err := getRoundPairings(g.redisClient, t.ID, t.Round)
+	if err != nil {
+		return false, err
+	}
+	busy := make(map[string]bool)
+	for _, pr := range pairings {
+		busy[pr.First], busy[pr.Second] = true, true
+	}
+	lastOpponent, err := g.redisClient.HGetAll(getArenaOpponentsKey(t.ID)).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get last opponents")
+	}
+
+	members, err := g.redisClient.ZRevRange(getTournamentStandingsKey(t.ID), 0, -1).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get tournament standings")
+	}
+	free := make([]string, 0, len(members))
+	for _, playerID := range members {
+		if busy[playerID] {
+			continue
+		}
+		in, err := inLobby(g.redisClient, playerID)
+		if err != nil {
+			return false, err
+		}
+		if in {
+			free = append(free, playerID)
+		}
+	}
+
+	changed := false
+	roundKey := getTournamentRoundKey(t.ID, t.Round)
+	for len(free) >= 2 {
+		first, j := free[0], 1
+		// avoid a rematch straight away when there is anyone else to play
+		for k := 1; k < len(free); k++ {
+			if lastOpponent[first] != free[k] {
+				j = k
+				break
+			}
+		}
+		second := free[j]
+		free = append(free[1:j], free[j+1:]...)
+
+		number, err := g.redisClient.HIncrBy(getTournamentKey(t.ID), "pairings", 1).Result()
+		if err != nil {
+			return changed, errors.Wrap(err, "failed to number pairing")
+		}
+		bs, err := json.Marshal(newPairing(int(number), first, second))
+		if err != nil {
+			return changed, errors.Wrap(err, "failed to encode pairing")
+		}
+		err = g.redisClient.HSetNX(roundKey, strconv.FormatInt(number, 10), string(bs)).Err()
+		if err != nil {
+			return changed, errors.Wrap(err, "failed to save pairing")
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// arenaPoints returns what a game of an arena is worth to a player given the streak it was on before the game
+func arenaPoints(winner, playerID string, streak int, berserk bool) float64 {
+	points := 0.0
+	switch winner {
+	case playerID:
+		points = arenaPointsWin
+	case "":
+		points = arenaPointsDraw
+	}
+	if streak >= arenaStreakForDouble {
+		points *= 2
+	}
+	if winner == playerID && berserk {
+		points += arenaBerserkBonus
+	}
+	return points
+}
+
+// scoreArenaPairing awards the points of an arena game and keeps track of the players' winning streaks
+func scoreArenaPairing(redisClient *redis.Client, t *tournament, pr *pairing, raw string, record *gameRecord) (bool, error) {
+	// a player only plays one game of an arena at a time, so its streak cannot change before the pairing does
+	streaks, err := redisClient.HMGet(getTournamentStreaksKey(t.ID), pr.First, pr.Second).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get arena streaks")
+	}
+
+	next := *pr
+	next.State = pairingStateDone
+	next.Winner = record.Winner
+
+	points := make(map[string]float64, 2)
+	newStreaks := make(map[string]int, 2)
+	for i, playerID := range []string{pr.First, pr.Second} {
+		saved, _ := streaks[i].(string)
+		streak, _ := strconv.Atoi(saved)
+		berserk := (playerID == record.PlayerX && record.BerserkX) || (playerID == record.PlayerO && record.BerserkO)
+		points[playerID] = arenaPoints(record.Winner, playerID, streak, berserk)
+		// a game that never started is lost by both players
+		if record.Outcome == outcomeAbandoned && record.Winner == "" {
+			points[playerID] = 0
+		}
+		newStreaks[playerID] = 0
+		if record.Winner == playerID {
+			newStreaks[playerID] = streak + 1
+		}
+	}
+
+	ok, err := finishArenaPairing(redisClient, t, raw, &next, points, newStreaks)
+	if ok {
+		*pr = next
+	}
+	return ok, err
+}
+
+// finishArenaPairingScript ends an arena pairing only if nobody has changed it in the meantime. The pairing
+// moves to the round's finished pairings and the players' points, winning streaks and last opponents are saved
+// in the same step
+//
+// KEYS: round key, finished pairings key, standings key, streaks key, last opponents key
+// ARGV: pairing number, expected pairing, new pairing, first player id, second player id, then the id, points and
+// new streak of each player whose game was scored
+var finishArenaPairingScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[3])
+redis.call("HMSET", KEYS[5], ARGV[4], ARGV[5], ARGV[5], ARGV[4])
+for i = 6, #ARGV, 3 do
+	redis.call("ZINCRBY", KEYS[3], ARGV[i + 1], ARGV[i])
+	redis.call("HSET", KEYS[4], ARGV[i], ARGV[i + 2])
+end
+return 1
+`)
+
+// finishArenaPairing ends an arena pairing. Players who did not play, e.g. because one of them did not turn up,
+// have no streaks and score no points
+func finishArenaPairing(
+	redisClient *redis.Client, t *tournament, raw string, next *pairing, points map[string]float64, streaks map[string]int,
+) (bool, error) {
+	bs, err := json.Marshal(next)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to encode pairing")
+	}
+	args := []interface{}{next.Number, raw, string(bs), next.First, next.Second}
+	for playerID, streak := range streaks {
+		args = append(args, playerID, points[playerID], streak)
+	}
+	i, err := finishArenaPairingScript.Run(
+		redisClient,
+		[]string{
+			getTournamentRoundKey(t.ID, t.Round),
+			getArenaFinishedKey(t.ID, t.Round),
+			getTournamentStandingsKey(t.ID),
+			getTournamentStreaksKey(t.ID),
+			getArenaOpponentsKey(t.ID),
+		},
+		args...,
+	).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to finish arena pairing")
+	}
+	return i == 1, nil
+}
+
+// isArenaGame reports whether a game is played in an arena
+func isArenaGame(redisClient *redis.Client, gameID string) (bool, error) {
+	id, err := redisClient.HGet(getGameKey(gameID), "tournament").Result()
+	if err != nil && err != redis.Nil {
+		return false, errors.Wrap(err, "failed to get tournament of game")
+	}
+	if id == "" {
+		return false, nil
+	}
+	t, err := getTournament(redisClient, id)
+	if err != nil {
+		return false, err
+	}
+	return t.Format == formatArena, nil
+}
+
+// ReturnToArena takes the player back to the lobby after an arena game, where the arena pairs it again. Arena
+// games are not followed by rematches
+func (p *player) ReturnToArena() error {
+	defer p.Reset()
+	p.info.State = playerStateFree
+	return p.WriteErrors(p.JoinFreePlayers(), p.PublishPlayerJoined())
+}
+
+// Berserk puts the player on a clock for the rest of its arena game in exchange for a bonus point if it wins.
+// Players may only go berserk before their first move
+func (p *player) Berserk() error {
+	if p.info.State != playerStatePlaying || p.board == nil {
+		return errors.New("you can only go berserk during a game")
+	}
+	if p.berserk {
+		return nil
+	}
+	if p.board.Moves() > 1 || (p.mark == markX && p.board.Moves() > 0) {
+		return errors.New("you can only go berserk before your first move")
+	}
+
+	arena, err := isArenaGame(p.redisClient, p.gameID)
+	if err != nil {
+		return err
+	}
+	if !arena {
+		return errors.New("you can only go berserk in an arena")
+	}
+
+	err = p.redisClient.HSet(getGameKey(p.gameID), "berserk"+p.mark, true).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to save berserk")
+	}
+	p.berserk = true
+
+	// Example payload: BERSERK:::myid
+	err = p.WriteErrors(
+		p.PublishMessageToGameChannel(playerBerserk(p.info.ID)),
+		p.PublishMessage(getGameChannel(p.gameID), playerBerserk(p.info.ID)),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to publish berserk message")
+	}
+	// the clock starts right away if it is the player's turn
+	return p.SendTurn()
+}
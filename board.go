@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// classic tic-tac-toe
+	defaultBoardSize = 3
+	minBoardSize     = 3
+	// moves on bigger boards would need more than two digits
+	maxBoardSize = 19
+	minWinLength = 3
+	// longest default win length; 15x15 boards are played five in a row
+	maxDefaultWinLength = 5
+
+	// marks
+	markNone = ""
+	markX    = "X"
+	markO    = "O"
+
+	// move ids are of the form box-<row><col> e.g box-13 (row 1, column 3) or, on boards of ten rows or more,
+	// box-<row>-<col> e.g box-12-15
+	movePrefix    = "box-"
+	moveSeparator = "-"
+
+	// move error codes
+	moveErrorMalformed   = "MALFORMED_MOVE"
+	moveErrorOutOfRange  = "OUT_OF_RANGE"
+	moveErrorCellTaken   = "CELL_TAKEN"
+	moveErrorNotYourTurn = "NOT_YOUR_TURN"
+	moveErrorGameOver    = "GAME_OVER"
+)
+
+// directions in which a line can run through a cell: across, down and both diagonals
+var lineDirections = []position{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+
+// moveError is sent to the client as the payload of an ERROR message when a move is rejected
+type moveError struct {
+	Code   string
+	Move   string
+	Reason string
+}
+
+func (e *moveError) Error() string {
+	return fmt.Sprintf("illegal move %q: %s", e.Move, e.Reason)
+}
+
+func newMoveError(code, moveID, reason string) *moveError {
+	return &moveError{Code: code, Move: moveID, Reason: reason}
+}
+
+type position struct {
+	row int
+	col int
+}
+
+// boardState is a snapshot of the board as seen by one of the players
+type boardState struct {
+	Mark   string
+	Turn   string
+	Moves  int
+	Over   bool
+	Winner string
+	// number of rows and columns, and how many marks in a row win
+	Size      int
+	WinLength int
+	// rule modifier of the game, if any
+	Modifier string
+	Cells    [][]string
+}
+
+// gameBoard is the state of a game in play, created by the rules of its variant. Moves are stored and published
+// by their move ids
+type gameBoard interface {
+	// ParseMove reads the payload of a client's move into a move id
+	ParseMove(payload interface{}) (string, error)
+	// MovePayload returns a move as it is sent to clients
+	MovePayload(moveID string) interface{}
+	// Check validates that mark can play moveID without changing the board
+	Check(mark, moveID string) error
+	// Play validates and applies moveID for mark
+	Play(mark, moveID string) error
+	// Replay plays moves in order starting with X
+	Replay(moves []string) error
+	// LegalMoves returns the ids of the moves the player whose turn it is may play
+	LegalMoves() []string
+	// Result returns the winning mark, if any, and whether the game is over
+	Result() (string, bool)
+	Turn() string
+	Moves() int
+	// State returns a snapshot of the board for the player with the given mark
+	State(mark string) interface{}
+	// Clone returns a copy of the board
+	Clone() gameBoard
+}
+
+type board struct {
+	mu        sync.Mutex // guards all fields below
+	size      int
+	winLength int
+	cells     [][]string
+	turn      string
+	moves     int
+	winner    string
+	// rule modifier changing what players place and how the game is won
+	modifier string
+}
+
+// newBoard returns an empty size x size board on which winLength marks in a row win
+func newBoard(size, winLength int) *board {
+	return &board{
+		size:      size,
+		winLength: winLength,
+		cells:     newCells(size),
+		turn:      markX,
+	}
+}
+
+// newClassicBoard returns an empty 3x3 board on which three in a row win
+func newClassicBoard() *board {
+	return newBoard(defaultBoardSize, defaultBoardSize)
+}
+
+func newCells(size int) [][]string {
+	cells := make([][]string, size)
+	for row := range cells {
+		cells[row] = make([]string, size)
+	}
+	return cells
+}
+
+func copyCells(cells [][]string) [][]string {
+	c := make([][]string, len(cells))
+	for row := range cells {
+		c[row] = append([]string(nil), cells[row]...)
+	}
+	return c
+}
+
+func opponentMark(mark string) string {
+	if mark == markX {
+		return markO
+	}
+	return markX
+}
+
+// formatMoveID returns the id of a move at pos on a board with the given number of rows
+func formatMoveID(pos position, size int) string {
+	if size > 9 {
+		return fmt.Sprintf("%s%d%s%d", movePrefix, pos.row+1, moveSeparator, pos.col+1)
+	}
+	return fmt.Sprintf("%s%d%d", movePrefix, pos.row+1, pos.col+1)
+}
+
+// parseMoveID reads a move on a board with the given number of rows. Both forms of move ids are accepted
+func parseMoveID(moveID string, size int) (position, error) {
+	if !strings.HasPrefix(moveID, movePrefix) {
+		return position{}, newMoveError(moveErrorMalformed, moveID, "expected move of the form box-<row><col>")
+	}
+	rest := moveID[len(movePrefix):]
+	var rowStr, colStr string
+	if i := strings.Index(rest, moveSeparator); i >= 0 {
+		rowStr, colStr = rest[:i], rest[i+1:]
+	} else if len(rest) == 2 {
+		rowStr, colStr = rest[:1], rest[1:]
+	} else {
+		return position{}, newMoveError(moveErrorMalformed, moveID, "expected move of the form box-<row><col>")
+	}
+	row, err := strconv.Atoi(rowStr)
+	if err != nil {
+		return position{}, newMoveError(moveErrorMalformed, moveID, "row is not a number")
+	}
+	col, err := strconv.Atoi(colStr)
+	if err != nil {
+		return position{}, newMoveError(moveErrorMalformed, moveID, "column is not a number")
+	}
+	if row < 1 || row > size || col < 1 || col > size {
+		return position{}, newMoveError(
+			moveErrorOutOfRange, moveID, fmt.Sprintf("row and column must be between 1 and %d", size),
+		)
+	}
+	return position{row: row - 1, col: col - 1}, nil
+}
+
+// ParseMove reads a move of the form box-<row><col>. Moves that place a chosen symbol may also be structured
+func (b *board) ParseMove(payload interface{}) (string, error) {
+	if moveID, ok := payload.(string); ok {
+		return moveID, nil
+	}
+	move := &symbolMove{}
+	if !hasSymbols(b.modifier) || decodePayload(payload, move) != nil {
+		return "", newMoveError(moveErrorMalformed, fmt.Sprint(payload), "expected move of the form box-<row><col>")
+	}
+	return move.Move + symbolSeparator + move.Symbol, nil
+}
+
+// MovePayload returns the move id itself, or the structured move if it places a chosen symbol
+func (b *board) MovePayload(moveID string) interface{} {
+	if !hasSymbols(b.modifier) {
+		return moveID
+	}
+	cell, symbol := splitSymbol(moveID)
+	return &symbolMove{Move: cell, Symbol: symbol}
+}
+
+// Check validates that mark can play moveID without changing the board
+func (b *board) Check(mark, moveID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, _, err := b.check(mark, moveID)
+	return err
+}
+
+// check returns the cell of the move and the symbol it places
+func (b *board) check(mark, moveID string) (position, string, error) {
+	cell, symbol := splitSymbol(moveID)
+	pos, err := parseMoveID(cell, b.size)
+	if err != nil {
+		return position{}, "", err
+	}
+	if b.over() {
+		return position{}, "", newMoveError(moveErrorGameOver, moveID, "game is already over")
+	}
+	if b.turn != mark {
+		return position{}, "", newMoveError(moveErrorNotYourTurn, moveID, "it is not your turn")
+	}
+	if b.cells[pos.row][pos.col] != markNone {
+		return position{}, "", newMoveError(moveErrorCellTaken, moveID, "cell is already taken")
+	}
+	symbol, err = b.checkSymbol(mark, moveID, symbol)
+	if err != nil {
+		return position{}, "", err
+	}
+	return pos, symbol, nil
+}
+
+// apply places symbol at pos for mark; in games without modifier players place their own mark
+func (b *board) apply(mark string, pos position, symbol string) {
+	b.cells[pos.row][pos.col] = symbol
+	b.turn = opponentMark(mark)
+	b.moves++
+	if winner, ok := b.wins(mark, pos); ok {
+		b.winner = winner
+	}
+}
+
+// completesLine reports whether the mark at pos is part of winLength marks in a row. Only the lines through pos
+// are looked at, so a check takes time in proportion to the win length rather than the size of the board
+func (b *board) completesLine(mark string, pos position) bool {
+	for _, dir := range lineDirections {
+		n := 1 + b.run(mark, pos, dir.row, dir.col) + b.run(mark, pos, -dir.row, -dir.col)
+		if n >= b.winLength {
+			return true
+		}
+	}
+	return false
+}
+
+// run counts the marks in a row from pos, not counting pos itself, going in the given direction
+func (b *board) run(mark string, pos position, dRow, dCol int) int {
+	n := 0
+	row, col := pos.row+dRow, pos.col+dCol
+	for n < b.winLength && b.inside(row, col) && b.cells[row][col] == mark {
+		n++
+		row, col = row+dRow, col+dCol
+	}
+	return n
+}
+
+func (b *board) inside(row, col int) bool {
+	return row >= 0 && row < b.size && col >= 0 && col < b.size
+}
+
+func (b *board) over() bool {
+	return b.winner != markNone || b.moves == b.size*b.size
+}
+
+// Turn returns the mark that plays next
+func (b *board) Turn() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.turn
+}
+
+// Moves returns the number of moves played
+func (b *board) Moves() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.moves
+}
+
+// Result returns the winning mark, if any, and whether the game is over. A finished game without winner is a draw
+func (b *board) Result() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.winner, b.over()
+}
+
+// Play validates and applies moveID for mark
+func (b *board) Play(mark, moveID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pos, symbol, err := b.check(mark, moveID)
+	if err != nil {
+		return err
+	}
+	b.apply(mark, pos, symbol)
+	return nil
+}
+
+// Replay plays moves in order starting with X
+func (b *board) Replay(moves []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, moveID := range moves {
+		pos, symbol, err := b.check(b.turn, moveID)
+		if err != nil {
+			return err
+		}
+		b.apply(b.turn, pos, symbol)
+	}
+	return nil
+}
+
+// Clone returns a copy of the board
+func (b *board) Clone() gameBoard {
+	return b.clone()
+}
+
+func (b *board) clone() *board {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &board{
+		size:      b.size,
+		winLength: b.winLength,
+		cells:     copyCells(b.cells),
+		turn:      b.turn,
+		moves:     b.moves,
+		winner:    b.winner,
+		modifier:  b.modifier,
+	}
+}
+
+// free returns the empty cells of the board
+func (b *board) free() []position {
+	positions := make([]position, 0, b.size*b.size-b.moves)
+	for row := 0; row < b.size; row++ {
+		for col := 0; col < b.size; col++ {
+			if b.cells[row][col] == markNone {
+				positions = append(positions, position{row: row, col: col})
+			}
+		}
+	}
+	return positions
+}
+
+// LegalMoves returns the ids of the empty cells, with every symbol that may be placed in them under the modifier
+func (b *board) LegalMoves() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	moves := make([]string, 0)
+	if b.over() {
+		return moves
+	}
+	symbols := b.symbols(b.turn)
+	for _, pos := range b.free() {
+		if !hasSymbols(b.modifier) {
+			moves = append(moves, formatMoveID(pos, b.size))
+			continue
+		}
+		for _, symbol := range symbols {
+			moves = append(moves, formatMoveID(pos, b.size)+symbolSeparator+symbol)
+		}
+	}
+	return moves
+}
+
+// BotMove returns the move a built-in bot of the given level plays with mark. Moves that place a chosen symbol
+// are searched like those of any other game
+func (b *board) BotMove(mark, level string, rnd *rand.Rand) string {
+	switch b.modifier {
+	case modifierWild:
+		return searchMove(b, mark, level, genericSearchDepth, rnd)
+	case modifierNumerical:
+		return searchMove(b, mark, level, numericalSearchDepth, rnd)
+	}
+	return formatMoveID(chooseMove(b, mark, level, rnd), b.size)
+}
+
+// State returns a snapshot of the board for the player with the given mark
+func (b *board) State(mark string) interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &boardState{
+		Mark:      mark,
+		Turn:      b.turn,
+		Moves:     b.moves,
+		Over:      b.over(),
+		Winner:    b.winner,
+		Size:      b.size,
+		WinLength: b.winLength,
+		Modifier:  b.modifier,
+		Cells:     copyCells(b.cells),
+	}
+}
+
+// restore returns a board with the cells and turn of the snapshot
+func (s *boardState) restore() (gameBoard, string) {
+	return &board{
+		size:      s.Size,
+		winLength: s.WinLength,
+		cells:     copyCells(s.Cells),
+		turn:      s.Turn,
+		moves:     s.Moves,
+		winner:    s.Winner,
+		modifier:  s.Modifier,
+	}, s.Mark
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBoardResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		winLength int
+		moves     []string
+		winner    string
+		over      bool
+	}{
+		{
+			name:      "row",
+			size:      3,
+			winLength: 3,
+			moves:     []string{"box-11", "box-21", "box-12", "box-22", "box-13"},
+			winner:    markX,
+			over:      true,
+		},
+		{
+			name:      "column",
+			size:      3,
+			winLength: 3,
+			moves:     []string{"box-11", "box-12", "box-21", "box-22", "box-33", "box-32"},
+			winner:    markO,
+			over:      true,
+		},
+		{
+			name:      "diagonal",
+			size:      3,
+			winLength: 3,
+			moves:     []string{"box-11", "box-12", "box-22", "box-13", "box-33"},
+			winner:    markX,
+			over:      true,
+		},
+		{
+			name:      "anti-diagonal",
+			size:      3,
+			winLength: 3,
+			moves:     []string{"box-13", "box-12", "box-22", "box-11", "box-31"},
+			winner:    markX,
+			over:      true,
+		},
+		{
+			name:      "draw",
+			size:      3,
+			winLength: 3,
+			moves: []string{
+				"box-11", "box-12", "box-13", "box-22", "box-21", "box-23", "box-32", "box-31", "box-33",
+			},
+			winner: markNone,
+			over:   true,
+		},
+		{
+			name:      "unfinished",
+			size:      3,
+			winLength: 3,
+			moves:     []string{"box-11", "box-12", "box-22"},
+			winner:    markNone,
+			over:      false,
+		},
+		{
+			name:      "four in a row on 5x5",
+			size:      5,
+			winLength: 4,
+			moves:     []string{"box-22", "box-11", "box-23", "box-12", "box-24", "box-13", "box-25"},
+			winner:    markX,
+			over:      true,
+		},
+		{
+			name:      "three in a row on 5x5 is not enough",
+			size:      5,
+			winLength: 4,
+			moves:     []string{"box-22", "box-11", "box-23", "box-12", "box-24"},
+			winner:    markNone,
+			over:      false,
+		},
+		{
+			name:      "line split by the other mark",
+			size:      5,
+			winLength: 4,
+			moves:     []string{"box-11", "box-13", "box-12", "box-51", "box-14", "box-52", "box-15"},
+			winner:    markNone,
+			over:      false,
+		},
+		{
+			name:      "five in a row along the edge of 15x15",
+			size:      15,
+			winLength: 5,
+			moves: []string{
+				"box-15-11", "box-1-1", "box-15-12", "box-1-2", "box-15-13", "box-1-3", "box-15-14", "box-1-4",
+				"box-15-15",
+			},
+			winner: markX,
+			over:   true,
+		},
+		{
+			name:      "diagonal into the corner of 15x15",
+			size:      15,
+			winLength: 5,
+			moves: []string{
+				"box-11-11", "box-1-1", "box-12-12", "box-1-2", "box-13-13", "box-1-3", "box-14-14", "box-2-1",
+				"box-15-15",
+			},
+			winner: markX,
+			over:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBoard(tt.size, tt.winLength)
+			err := b.Replay(tt.moves)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			winner, over := b.Result()
+			if winner != tt.winner || over != tt.over {
+				t.Errorf("Result() = %q, %v, want %q, %v", winner, over, tt.winner, tt.over)
+			}
+		})
+	}
+}
+
+func TestBoardPlay(t *testing.T) {
+	tests := []struct {
+		name   string
+		moves  []string
+		mark   string
+		moveID string
+		code   string
+	}{
+		{
+			name:   "first move",
+			mark:   markX,
+			moveID: "box-22",
+		},
+		{
+			name:   "long form move id",
+			mark:   markX,
+			moveID: "box-2-2",
+		},
+		{
+			name:   "not your turn",
+			mark:   markO,
+			moveID: "box-22",
+			code:   moveErrorNotYourTurn,
+		},
+		{
+			name:   "cell taken",
+			moves:  []string{"box-22"},
+			mark:   markO,
+			moveID: "box-22",
+			code:   moveErrorCellTaken,
+		},
+		{
+			name:   "out of range",
+			mark:   markX,
+			moveID: "box-41",
+			code:   moveErrorOutOfRange,
+		},
+		{
+			name:   "malformed",
+			mark:   markX,
+			moveID: "cell-22",
+			code:   moveErrorMalformed,
+		},
+		{
+			name:   "symbol without modifier",
+			mark:   markX,
+			moveID: "box-22/O",
+			code:   moveErrorMalformed,
+		},
+		{
+			name:   "game over",
+			moves:  []string{"box-11", "box-21", "box-12", "box-22", "box-13"},
+			mark:   markO,
+			moveID: "box-33",
+			code:   moveErrorGameOver,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newClassicBoard()
+			err := b.Replay(tt.moves)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			err = b.Play(tt.mark, tt.moveID)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("Play() error = %v", err)
+				}
+				return
+			}
+			moveErr, ok := err.(*moveError)
+			if !ok || moveErr.Code != tt.code {
+				t.Errorf("Play() error = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestParseMoveID(t *testing.T) {
+	tests := []struct {
+		moveID string
+		size   int
+		pos    position
+		valid  bool
+	}{
+		{moveID: "box-13", size: 3, pos: position{row: 0, col: 2}, valid: true},
+		{moveID: "box-1-3", size: 3, pos: position{row: 0, col: 2}, valid: true},
+		{moveID: "box-12-15", size: 15, pos: position{row: 11, col: 14}, valid: true},
+		{moveID: "box-16-1", size: 15},
+		{moveID: "box-00", size: 3},
+		{moveID: "box-123", size: 15},
+		{moveID: "box-ab", size: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.moveID, func(t *testing.T) {
+			pos, err := parseMoveID(tt.moveID, tt.size)
+			if (err == nil) != tt.valid {
+				t.Fatalf("parseMoveID() error = %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid && pos != tt.pos {
+				t.Errorf("parseMoveID() = %v, want %v", pos, tt.pos)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how long a node holds the right to run a bot without renewing it
+	botLockTTL = 15 * time.Second
+	// how often the lock is renewed and free nodes try to take over bots
+	botLockInterval = 5 * time.Second
+	// pause before the bot answers so that it feels like a person playing
+	botThinkTime = 700 * time.Millisecond
+	// how long a bot started for a challenge waits for it before it stops
+	spawnedBotIdle = 30 * time.Second
+)
+
+// playerConn is the connection to a player's client; a websocket for people and a botConn for bots
+type playerConn interface {
+	ReadJSON(v interface{}) error
+	WriteJSON(v interface{}) error
+	Close() error
+}
+
+// botConn plays for a bot. Messages the server writes to the bot are answered with messages the server reads
+// back, exactly as a browser would
+type botConn struct {
+	ctx      context.Context
+	level    string
+	incoming chan *message
+	rnd      *rand.Rand
+	mu       sync.Mutex // guards fields below
+	mark     string
+	board    gameBoard
+}
+
+func newBotConn(ctx context.Context, level string) *botConn {
+	return &botConn{
+		ctx:      ctx,
+		level:    level,
+		incoming: make(chan *message, 8),
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func getBotID(level string) string {
+	return "bot#" + level
+}
+
+// isBuiltinBot reports whether a player is one of the server's own bots, either one in the lobby or one started
+// for a challenge. Bots registered through the api are not
+func isBuiltinBot(playerID string) bool {
+	if !strings.HasPrefix(playerID, "bot#") {
+		return false
+	}
+	level := strings.SplitN(strings.TrimPrefix(playerID, "bot#"), "#", 2)[0]
+	return contains(botLevels, level)
+}
+
+// botName returns the name of the server's bot of the given level e.g Easy Bot
+func botName(level string) string {
+	return strings.ToUpper(level[:1]) + level[1:] + " Bot"
+}
+
+func getBotLockKey(level string) string {
+	return "lock:bot:" + level
+}
+
+// ReadJSON returns the next message of the bot
+func (c *botConn) ReadJSON(v interface{}) error {
+	msg, ok := v.(*message)
+	if !ok {
+		return errors.Errorf("bot cannot read into %T", v)
+	}
+	select {
+	case <-c.ctx.Done():
+		return errors.New("bot stopped")
+	case next := <-c.incoming:
+		*msg = *next
+		return nil
+	}
+}
+
+// WriteJSON lets the bot react to a message from the server
+func (c *botConn) WriteJSON(v interface{}) error {
+	msg, ok := v.(*message)
+	if !ok {
+		return nil
+	}
+
+	switch msg.Type {
+	case messagePlayerRequestGame:
+		// always up for a game
+		if opponent, ok := msg.Payload.(*challenge); ok {
+			c.send(messagePlayerAcceptGame, opponent.ID)
+		}
+	case messageRematch:
+		c.send(messageAcceptRematch, "")
+	case messageGameOn:
+		c.mu.Lock()
+		if state, ok := msg.Payload.(boardSnapshot); ok {
+			c.board, c.mark = state.restore()
+		}
+		c.mu.Unlock()
+		c.play()
+	case messagePlayerMove:
+		c.mu.Lock()
+		if c.board == nil {
+			c.mu.Unlock()
+			break
+		}
+		moveID, err := c.board.ParseMove(msg.Payload)
+		if err == nil {
+			err = c.board.Play(opponentMark(c.mark), moveID)
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		c.play()
+	case messageGameWon, messageGameDraw:
+		// offer a rematch; the game is left if the opponent does not take it
+		c.send(messageRematch, "")
+	}
+	return nil
+}
+
+// play makes the bot's move if it is its turn
+func (c *botConn) play() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.board == nil {
+		return
+	}
+	if _, over := c.board.Result(); over || c.board.Turn() != c.mark {
+		return
+	}
+	moveID := chooseBotMove(c.board, c.mark, c.level, c.rnd)
+	logError(c.board.Play(c.mark, moveID))
+	c.send(messagePlayerMove, moveID)
+}
+
+// send queues a message from the bot to the server after the bot's think time
+func (c *botConn) send(messageType string, payload interface{}) {
+	go func() {
+		select {
+		case <-c.ctx.Done():
+		case <-time.After(botThinkTime):
+			select {
+			case <-c.ctx.Done():
+			case c.incoming <- &message{Type: messageType, Payload: payload}:
+			}
+		}
+	}()
+}
+
+func (c *botConn) Close() error {
+	return nil
+}
+
+// renewBotLockScript extends the lock on a bot if this node still holds it
+//
+// KEYS: bot lock key
+// ARGV: node token, ttl in milliseconds
+var renewBotLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// superviseBot keeps a bot of the given level running on exactly one node of the cluster.
+// Every node competes for the bot's lock; the winner runs the bot for as long as it renews the lock
+func (g *game) superviseBot(level string) {
+	token, err := newSessionToken()
+	if err != nil {
+		logError(err)
+		return
+	}
+	key := getBotLockKey(level)
+
+	ticker := time.NewTicker(botLockInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		ok, err := g.redisClient.SetNX(key, token, botLockTTL).Result()
+		if err != nil {
+			logError(err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			// give up the bot if the lock cannot be renewed
+			defer cancel()
+			renew := time.NewTicker(botLockInterval)
+			defer renew.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-renew.C:
+					i, err := renewBotLockScript.Run(
+						g.redisClient, []string{key}, token, int64(botLockTTL/time.Millisecond),
+					).Int64()
+					if err != nil || i == 0 {
+						logError(err)
+						return
+					}
+				}
+			}
+		}()
+
+		logrus.Infoln("running bot: ", level)
+		logError(g.RunBot(ctx, cancel, level))
+		cancel()
+		logrus.Infoln("stopped bot: ", level)
+	}
+}
+
+// RunBot joins the lobby as a bot of the given level and plays until ctx is cancelled
+func (g *game) RunBot(ctx context.Context, cancel func(), level string) error {
+	p := &player{
+		ctx:         ctx,
+		cancel:      cancel,
+		mu:          &sync.Mutex{},
+		conn:        newBotConn(ctx, level),
+		game:        g,
+		redisClient: g.redisClient,
+		waitingChan: make(chan struct{}, 0),
+		handedOff:   make(chan struct{}, 0),
+		requests:    make(chan *message),
+		events:      make(chan func()),
+	}
+
+	var err error
+	p.info, err = getOrCreateBot(g.redisClient, level)
+	if err != nil {
+		return err
+	}
+
+	err = p.Subscribe()
+	if err != nil {
+		return err
+	}
+
+	err = p.JoinGame()
+	if err != nil {
+		return err
+	}
+
+	p.Reset()
+	p.Run()
+	return nil
+}
+
+func getOrCreateBot(redisClient *redis.Client, level string) (*playerInfo, error) {
+	botID := getBotID(level)
+
+	i, err := redisClient.Exists(botID).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check for bot")
+	}
+	if i == 0 {
+		err = saveInCache(&playerInfo{
+			ID:               botID,
+			State:            playerStateFree,
+			Rating:           defaultRating,
+			RatingDeviation:  defaultDeviation,
+			RatingVolatility: defaultVolatility,
+			Bot:              true,
+		}, redisClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save bot")
+		}
+	} else {
+		// bots saved before players could be marked as bots
+		err = redisClient.HSet(botID, "bot", true).Err()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to mark bot")
+		}
+	}
+
+	// bots get a fixed name; it may be taken only if a person chose it before the bot first ran
+	_, err = claimName(redisClient, botID, botName(level))
+	if err != nil {
+		return nil, err
+	}
+
+	return getPlayerFromRedis(redisClient, botID)
+}
+
+// SpawnBot starts a bot of the given level on this node to answer a single challenge and returns its id. Every
+// challenge gets a bot of its own, so the bots in the lobby are never busy. The bot is not listed in the lobby and
+// stops once it is back there, or if the challenge does not come
+func (g *game) SpawnBot(level string) (string, error) {
+	suffix, err := newGameID()
+	if err != nil {
+		return "", err
+	}
+	info := &playerInfo{
+		ID:               getBotID(level) + "#" + suffix,
+		Name:             botName(level),
+		State:            playerStateFree,
+		Rating:           defaultRating,
+		RatingDeviation:  defaultDeviation,
+		RatingVolatility: defaultVolatility,
+		Bot:              true,
+	}
+	err = saveInCache(info, g.redisClient)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to save bot")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &player{
+		ctx:         ctx,
+		cancel:      cancel,
+		mu:          &sync.Mutex{},
+		conn:        newBotConn(ctx, level),
+		game:        g,
+		redisClient: g.redisClient,
+		info:        info,
+		waitingChan: make(chan struct{}, 0),
+		handedOff:   make(chan struct{}, 0),
+		requests:    make(chan *message),
+		events:      make(chan func()),
+		spawned:     true,
+	}
+	err = p.Subscribe()
+	if err != nil {
+		cancel()
+		p.Unsubscribe()
+		return "", err
+	}
+
+	p.after(spawnedBotIdle, func() {
+		if p.info.State == playerStateFree {
+			p.cancel()
+		}
+	})
+	go p.Run()
+	return info.ID, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hash of the sha256 of bot api keys to the id of the bot they belong to
+const botKeysHash = "hash:bots:keys"
+
+type botSignup struct {
+	Name string
+}
+
+// registeredBot is returned once when a bot is registered; the key cannot be looked up again
+type registeredBot struct {
+	ID   string
+	Name string
+	Key  string
+}
+
+// botTurn tells a bot that it is its turn and by when it has to move
+type botTurn struct {
+	Board interface{}
+	// unix time in milliseconds after which the bot forfeits the game
+	Deadline int64
+}
+
+func newBotKey() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate bot api key")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// only hashes of api keys are stored so that a copy of the database cannot be used to connect as a bot
+func hashBotKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token of a bearer authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
+// RegisterBot creates a bot player and returns the api key it connects with.
+// Example: POST /bots {"Name": "Deep Cross"}
+func (g *game) RegisterBot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// bots can only be registered on servers that have a registration key
+	if g.botSignupKey == "" {
+		http.Error(w, "bot registration is disabled", http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(g.botSignupKey)) != 1 {
+		http.Error(w, "invalid registration key", http.StatusUnauthorized)
+		return
+	}
+
+	signup := &botSignup{}
+	err := json.NewDecoder(r.Body).Decode(signup)
+	if err != nil {
+		http.Error(w, "malformed request body", http.StatusBadRequest)
+		return
+	}
+	signup.Name = strings.TrimSpace(signup.Name)
+	err = validateName(signup.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := newPlayerID()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	botID := "bot#" + strings.TrimPrefix(id, "player#")
+
+	ok, err := claimName(g.redisClient, botID, signup.Name)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "name is taken", http.StatusConflict)
+		return
+	}
+
+	err = saveInCache(&playerInfo{
+		ID:               botID,
+		Name:             signup.Name,
+		State:            playerStateFree,
+		Rating:           defaultRating,
+		RatingDeviation:  defaultDeviation,
+		RatingVolatility: defaultVolatility,
+		Bot:              true,
+	}, g.redisClient)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	key, err := newBotKey()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = g.redisClient.HSet(botKeysHash, hashBotKey(key), botID).Err()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, &registeredBot{ID: botID, Name: signup.Name, Key: key})
+}
+
+// BotJoin connects a registered bot to the lobby. From there on a bot speaks the same protocol as any other
+// client and is additionally sent YOURTURN whenever it has to move.
+// The key is only taken from the header so that it never shows up in access logs.
+// Example: /ws/bot with an Authorization: Bearer 5b1f0c... header
+func (g *game) BotJoin(w http.ResponseWriter, r *http.Request) {
+	key := bearerToken(r)
+	if key == "" {
+		http.Error(w, "missing api key", http.StatusUnauthorized)
+		return
+	}
+
+	botID, err := g.redisClient.HGet(botKeysHash, hashBotKey(key)).Result()
+	if err == redis.Nil {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+
+	p := &player{
+		ctx:         ctx,
+		cancel:      cancel,
+		mu:          &sync.Mutex{},
+		game:        g,
+		redisClient: g.redisClient,
+		waitingChan: make(chan struct{}, 0),
+		handedOff:   make(chan struct{}, 0),
+		requests:    make(chan *message),
+		events:      make(chan func()),
+	}
+
+	p.info, err = getPlayerFromRedis(g.redisClient, botID)
+	if err != nil {
+		cancel()
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// upgrade connection to websocket
+	p.conn, err = upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		cancel()
+		logError(err)
+		return
+	}
+
+	err = p.Subscribe()
+	if err != nil {
+		cancel()
+		logError(err)
+		p.WriteError(err)
+		return
+	}
+
+	err = p.WriteError(p.JoinGame())
+	if err != nil {
+		cancel()
+		p.Unsubscribe()
+		return
+	}
+
+	p.Reset()
+
+	// we send information about the bot and the list of online players
+	err = p.WriteErrors(
+		p.WriteJSON(&message{Type: messageWelcome, Payload: p.info}),
+		p.WriteJSON(&message{Type: messageAllPlayers, Payload: p.game.FreePlayers()}),
+	)
+	if err != nil {
+		cancel()
+		p.Unsubscribe()
+		logError(p.LeaveGame())
+		return
+	}
+
+	p.Run()
+}
+
+// moveTime returns how long the player has for each move; zero if it may take as long as it likes
+func (p *player) moveTime() time.Duration {
+	switch {
+	case p.info.Bot && !isBuiltinBot(p.info.ID):
+		// only bots registered through the api are on a clock; the built-in ones answer at once
+		return p.game.botDeadline
+	case p.berserk:
+		return berserkMoveTime
+	}
+	return 0
+}
+
+// SendTurn sends a player that is on a clock, such as a bot, the board when it is its turn and starts the
+// clock on its move
+func (p *player) SendTurn() error {
+	moveTime := p.moveTime()
+	if moveTime == 0 || p.board == nil {
+		return nil
+	}
+	if _, over := p.board.Result(); over || p.board.Turn() != p.mark {
+		return nil
+	}
+
+	gameID, moves := p.gameID, p.board.Moves()
+	deadline := time.Now().Add(moveTime)
+
+	p.StopTurnClock()
+	// the deadline is handled by the player's loop, so a move that arrives in time is always applied first
+	p.turnTimer = p.after(moveTime, func() {
+		// nothing to do if the bot has moved or the game has ended in the meantime
+		if p.info.State != playerStatePlaying || p.gameID != gameID ||
+			p.board == nil || p.board.Moves() != moves {
+			return
+		}
+		p.WriteErrorString("move deadline exceeded")
+		p.WriteError(p.Forfeit())
+	})
+
+	return p.WriteJSON(&message{
+		Type: messageYourTurn,
+		Payload: &botTurn{
+			Board:    p.board.State(p.mark),
+			Deadline: deadline.UnixNano() / int64(time.Millisecond),
+		},
+	})
+}
+
+func (p *player) StopTurnClock() {
+	if p.turnTimer != nil {
+		p.turnTimer.Stop()
+		p.turnTimer = nil
+	}
+}
+
+// WonByForfeit reports whether an announced result of a game that is not over yet is the player's win by
+// forfeit, as committed to the game record
+func (p *player) WonByForfeit(messageType, winnerID string) (bool, error) {
+	if messageType != messageGameWon || winnerID != p.info.ID {
+		return false, nil
+	}
+	outcome, err := p.redisClient.HMGet(getGameKey(p.gameID), "outcome", "winner").Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get result of game")
+	}
+	return outcome[0] == p.mark && outcome[1] == p.info.ID, nil
+}
+
+// Forfeit ends the current game as a loss for the player
+func (p *player) Forfeit() error {
+	winner := opponentMark(p.mark)
+	err := p.CommitResult(winner)
+	if err != nil {
+		return err
+	}
+	err = p.PublishGameWon(p.opponent.ID)
+	if err != nil {
+		return err
+	}
+	return p.GameOver(winner)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
 	"strconv"
@@ -8,12 +9,17 @@ import (
 
 func saveInCache(p *playerInfo, redisClient *redis.Client) error {
 	return redisClient.HMSet(p.ID, map[string]interface{}{
-		"id":    p.ID,
-		"name":  p.Name,
-		"state": p.State,
-		"won":   p.Won,
-		"draw":  p.Draw,
-		"lost":  p.Lost,
+		"id":         p.ID,
+		"name":       p.Name,
+		"state":      p.State,
+		"won":        p.Won,
+		"draw":       p.Draw,
+		"lost":       p.Lost,
+		"rating":     p.Rating,
+		"deviation":  p.RatingDeviation,
+		"volatility": p.RatingVolatility,
+		"streak":     p.Streak,
+		"bot":        p.Bot,
 	}).Err()
 }
 
@@ -23,12 +29,16 @@ func getPlayerFromRedis(redisClient *redis.Client, key string) (*playerInfo, err
 		return nil, errors.Wrap(err, "failed to get from map")
 	}
 	p := &playerInfo{
-		ID:    playerMap["id"],
-		Name:  playerMap["name"],
-		State: playerMap["state"],
-		Won:   0,
-		Lost:  0,
-		Draw:  0,
+		ID:               playerMap["id"],
+		Name:             playerMap["name"],
+		State:            playerMap["state"],
+		Won:              0,
+		Lost:             0,
+		Draw:             0,
+		Rating:           defaultRating,
+		RatingDeviation:  defaultDeviation,
+		RatingVolatility: defaultVolatility,
+		Bot:              playerMap["bot"] == "1",
 	}
 	if playerMap["won"] != "" {
 		p.Won, err = strconv.Atoi(playerMap["won"])
@@ -51,9 +61,60 @@ func getPlayerFromRedis(redisClient *redis.Client, key string) (*playerInfo, err
 		}
 	}
 
+	if playerMap["streak"] != "" {
+		p.Streak, err = strconv.Atoi(playerMap["streak"])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert streak to int")
+		}
+	}
+
+	if playerMap["rating"] != "" {
+		p.Rating, err = strconv.ParseFloat(playerMap["rating"], 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert rating to float")
+		}
+	}
+
+	if playerMap["deviation"] != "" {
+		p.RatingDeviation, err = strconv.ParseFloat(playerMap["deviation"], 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert deviation to float")
+		}
+	}
+
+	if playerMap["volatility"] != "" {
+		p.RatingVolatility, err = strconv.ParseFloat(playerMap["volatility"], 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert volatility to float")
+		}
+	}
+
 	return p, nil
 }
 
+// getPlayerRatingsKey returns the list of a player's rating changes, oldest first
+func getPlayerRatingsKey(playerID string) string {
+	return "list:ratings:" + playerID
+}
+
+// getRatingHistory returns the player's rating changes, newest first
+func getRatingHistory(redisClient *redis.Client, playerID string, offset, limit int64) ([]*ratingChange, error) {
+	entries, err := redisClient.LRange(getPlayerRatingsKey(playerID), -offset-limit, -offset-1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get rating history")
+	}
+	changes := make([]*ratingChange, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		change := &ratingChange{}
+		err = json.Unmarshal([]byte(entries[i]), change)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode rating change")
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
 func getPlayerKey(id string) string {
 	return "players:" + id
 }
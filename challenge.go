@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// series lengths players may agree on; 1 is a single game
+var seriesLengths = []int{1, 3, 5, 7}
+
+// gameSettings are the rules two players agree on when one challenges the other
+type gameSettings struct {
+	// number of games in a series, of which the player with more than half the points wins
+	BestOf int
+	// game to play, one of the registered rules
+	Variant string
+	// number of rows and columns of the board and how many marks in a row win; chosen in classic games only
+	Size      int
+	WinLength int
+	// misere, wild or numerical changes the rules of a classic game; empty plays it as is
+	Modifier string
+}
+
+// challengeRequest is sent by a client to challenge another player
+type challengeRequest struct {
+	Player string
+	gameSettings
+}
+
+// challenge is sent to the client of a challenged player; it is the challenger with the settings it asked for
+type challenge struct {
+	*playerInfo
+	Settings *gameSettings
+}
+
+func defaultGameSettings() *gameSettings {
+	return &gameSettings{BestOf: 1, Variant: variantClassic, Size: defaultBoardSize, WinLength: defaultBoardSize}
+}
+
+// validateSettings fills in defaults and checks the settings of a challenge; the rules of the game check the rest
+func validateSettings(s *gameSettings) error {
+	if s.BestOf == 0 {
+		s.BestOf = 1
+	}
+	if !containsInt(seriesLengths, s.BestOf) {
+		return errors.Errorf("series may be best of %v games", seriesLengths)
+	}
+	if s.Variant == "" {
+		s.Variant = variantClassic
+	}
+	r, err := getRules(s.Variant)
+	if err != nil {
+		return err
+	}
+	return r.Validate(s)
+}
+
+// newBoard returns the initial board of the game of the settings; nil settings are a classic game
+func (s *gameSettings) newBoard() gameBoard {
+	if s == nil {
+		return newClassicBoard()
+	}
+	r, err := getRules(s.Variant)
+	if err != nil {
+		return newClassicBoard()
+	}
+	return r.NewBoard(s)
+}
+
+func containsInt(ns []int, n int) bool {
+	for _, m := range ns {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// parseChallengeRequest reads the payload of a REQUESTGAME message, which is either the id of the challenged
+// player or a challenge request
+func parseChallengeRequest(payload interface{}) (*challengeRequest, error) {
+	req := &challengeRequest{}
+	switch v := payload.(type) {
+	case string:
+		req.Player = v
+	default:
+		err := decodePayload(payload, req)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.Player == "" {
+		return nil, errors.New("missing player to challenge")
+	}
+	err := validateSettings(&req.gameSettings)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// parseChallenge reads a published challenge into the challenger's id and the settings it asked for.
+// Example payload: player#9f2c41d07ab3e5f6|{"BestOf":3}
+func parseChallenge(payload string) (string, *gameSettings, error) {
+	ss := strings.SplitN(payload, payloadSplit, 2)
+	settings := defaultGameSettings()
+	if len(ss) < 2 {
+		return ss[0], settings, nil
+	}
+	err := json.Unmarshal([]byte(ss[1]), settings)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to decode game settings")
+	}
+	return ss[0], settings, validateSettings(settings)
+}
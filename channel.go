@@ -1,13 +1,45 @@
 package main
 
 import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strconv"
 	"time"
 )
 
+// Subscribe subscribes the player to the players and lobby chat channels and to its own channel
+func (p *player) Subscribe() error {
+	p.free = p.redisClient.Subscribe(playersChannel, lobbyChatChannel)
+	p.own = p.redisClient.Subscribe(p.info.ID)
+	// wait for the subscriptions to be confirmed so that no message sent afterwards is missed
+	for i := 0; i < 2; i++ {
+		_, err := p.free.Receive()
+		if err != nil {
+			return errors.Wrap(err, "failed to subscribe to players channel")
+		}
+	}
+	_, err := p.own.Receive()
+	if err != nil {
+		return errors.Wrap(err, "failed to subscribe to player channel")
+	}
+	return nil
+}
+
+// Unsubscribe closes the player's subscriptions. It is only needed if the player's loop never ran
+func (p *player) Unsubscribe() {
+	if p.free != nil {
+		logError(p.free.Close())
+	}
+	if p.own != nil {
+		logError(p.own.Close())
+	}
+}
+
+// ReadChannels is the player's loop. It handles the client's requests, pub/sub messages and timers one at a time
 func (p *player) ReadChannels() {
-	// subscribe to channels
-	free := p.redisClient.Subscribe(playersChannel)
-	own := p.redisClient.Subscribe(p.info.ID)
+	free, own := p.free, p.own
+	defer free.Close()
+	defer own.Close()
 
 	var err error
 
@@ -15,25 +47,16 @@ func (p *player) ReadChannels() {
 		select {
 		case <-p.ctx.Done():
 			return
+		case msg := <-p.requests:
+			p.HandleRequest(msg)
+		case fn := <-p.events:
+			fn()
 		case msg := <-free.Channel():
 			broadMessageType, payload := fromBroadCast(msg.Payload)
 			switch broadMessageType {
 			case messagePlayerJoin:
-				timer := time.NewTimer(time.Second)
-				// backoff
-				for i := 0; i < 5; i++ {
-					newPlayer := p.game.GetPlayer(payload)
-					if newPlayer == nil {
-						<-timer.C
-						continue
-					}
-					p.WriteJSON(&message{
-						Type:    messagePlayerJoin,
-						Payload: newPlayer,
-					})
-					timer.Stop()
-					break
-				}
+				// the new player is looked up on the side so that the loop is not held up while we wait for it
+				go p.SendPlayerJoined(payload)
 
 			case messagePlayerLeft:
 				// send the id of the player to client
@@ -41,6 +64,46 @@ func (p *player) ReadChannels() {
 					Type:    messagePlayerLeft,
 					Payload: payload,
 				})
+			case messageLobbyChat:
+				lobbyMsg := &lobbyMessage{}
+				err = json.Unmarshal([]byte(payload), lobbyMsg)
+				if err != nil {
+					logError(err)
+					break
+				}
+				p.WriteJSON(&message{
+					Type:    messageLobbyChat,
+					Payload: lobbyMsg,
+				})
+			case messageLobbyDelete:
+				id, err := strconv.ParseInt(payload, 10, 64)
+				if err != nil {
+					logError(err)
+					break
+				}
+				p.WriteJSON(&message{
+					Type:    messageLobbyDelete,
+					Payload: id,
+				})
+			case messageTournament:
+				// standings are only sent to players who follow the tournament
+				if p.Following(payload) {
+					p.WriteError(p.SendTournament(payload))
+				}
+			case messagePlayerNameChanged:
+				changed, err := getPlayerFromRedis(p.redisClient, payload)
+				if err != nil {
+					logError(err)
+					break
+				}
+				if p.opponent != nil && p.opponent.ID == changed.ID {
+					p.opponent.Name = changed.Name
+				}
+				// send the player with its new name to client
+				p.WriteJSON(&message{
+					Type:    messagePlayerNameChanged,
+					Payload: changed,
+				})
 			}
 		case msg := <-own.Channel():
 			broadMessageType, payload := fromBroadCast(msg.Payload)
@@ -49,24 +112,37 @@ func (p *player) ReadChannels() {
 				// check that you are not playing or waiting for other player
 				if p.info.State != playerStateFree {
 					// publish busy message
-					p.WriteError(p.PublishBusyMessage(payload))
+					opponentID, _, _ := parseChallenge(payload)
+					p.WriteError(p.PublishBusyMessage(opponentID))
 					break
 				}
-				// get opponent
-				opponent, err := getPlayerFromRedis(p.redisClient, payload)
+				// get opponent and the settings it asked for
+				opponentID, settings, err := parseChallenge(payload)
+				if err != nil {
+					p.WriteError(err)
+					break
+				}
+				opponent, err := getPlayerFromRedis(p.redisClient, opponentID)
 				if err != nil {
 					p.WriteError(err)
 					break
 				}
 				p.InitWaitingChan()
 				p.opponent = opponent
+				p.settings = settings
+				p.challenged = true
 				p.info.State = playerStateRequesting
 				// notify the client that someone want to play; send along the opponent details
 				p.WriteJSON(&message{
 					Type:    messagePlayerRequestGame,
-					Payload: opponent,
+					Payload: &challenge{playerInfo: opponent, Settings: settings},
 				})
 			case messagePlayerBusy:
+				// only the player we are waiting on can turn out to be busy; a game already started is ended
+				// by MATCHREFUSED instead
+				if p.info.State != playerStateRequesting || p.opponent == nil || p.opponent.ID != payload {
+					break
+				}
 				p.CloseWaitingChan()
 				// tell your client other player is busy
 				p.WriteJSON(&message{
@@ -84,8 +160,27 @@ func (p *player) ReadChannels() {
 					break
 				}
 				p.Reset()
+			case messagePlayerMatched:
+				// the matchmaker, a tournament or a room paired us with an opponent
+				if !p.matchable() {
+					p.WriteError(p.RefuseMatch(payload))
+					break
+				}
+				p.WriteError(p.Matched(payload))
+			case messageMatchRefused:
+				p.WriteError(p.MatchRefused(payload))
 			case messagePlayerStartGame: // STEP 4
-				p.StartGame()
+				// only the player we challenged can start the game, and only while we wait for the answer. A
+				// game started after we gave up waiting is refused
+				gameID, playerID := fromStartGame(payload)
+				if p.info.State == playerStateRequesting && !p.challenged && p.opponent != nil &&
+					p.opponent.ID == playerID {
+					p.StartGame(gameID, markX)
+					break
+				}
+				if playerID != "" {
+					p.WriteError(p.RefuseGame(gameID, playerID, markX))
+				}
 			case messagePlayerMove: // STEP 6
 				// we check our state
 				if p.info.State != playerStatePlaying {
@@ -93,48 +188,101 @@ func (p *player) ReadChannels() {
 					p.ExitGameAndPublish()
 					break
 				}
+				// skip moves the board already has e.g after resuming a session
+				number, moveID := fromMoveBroadCast(payload)
+				if number <= p.board.Moves() {
+					break
+				}
+				// keep our copy of the board in sync; an illegal move means the boards disagree
+				err = p.board.Play(opponentMark(p.mark), moveID)
+				if err != nil {
+					p.WriteError(err)
+					p.ExitGameAndPublish()
+					break
+				}
 				// consume the other player's move by forwarding it to client
 				p.WriteJSON(&message{
 					Type:    messagePlayerMove,
-					Payload: payload,
+					Payload: p.board.MovePayload(moveID),
 				})
-			case messageGameDraw:
-				p.InitWaitingChan()
-				err := p.WriteError(p.redisClient.HIncrBy(p.info.ID, "draw", 1).Err())
-				if err != nil {
+				p.WriteError(p.SendTurn())
+			case messageGameDraw, messageGameWon:
+				// the result is taken from our own board, not from the message
+				if p.info.State != playerStatePlaying {
 					break
 				}
-				p.info.Draw++
-				p.info.State = playerStateGameOver
-				p.WriteJSON(&message{
-					Type:    messageGameDraw,
-					Payload: "Draw!",
-				})
-			case messageGameWon:
-				p.InitWaitingChan()
-				if payload == p.info.ID {
-					err := p.WriteError(p.redisClient.HIncrBy(p.info.ID, "won", 1).Err())
-					if err != nil {
-						break
-					}
-					p.info.Won++
-				} else {
-					err := p.WriteError(p.redisClient.HIncrBy(p.info.ID, "lost", 1).Err())
-					if err != nil {
+				winner, over := p.board.Result()
+				if !over {
+					// a bot that runs out of time loses the game before the board is full. The forfeit is
+					// committed before it is announced, so the announcement is only believed if the record agrees
+					won, err := p.WonByForfeit(broadMessageType, payload)
+					if err != nil || !won {
+						p.WriteError(err)
+						p.WriteErrorString("opponent announced a result but the game is not over")
+						p.ExitGameAndPublish()
 						break
 					}
-					p.info.Lost++
+					winner = p.mark
+				}
+				p.WriteError(p.GameOver(winner))
+			case messageChat:
+				if p.opponent == nil {
+					break
+				}
+				chatMsg := &chatMessage{}
+				err = json.Unmarshal([]byte(payload), chatMsg)
+				if err != nil {
+					logError(err)
+					break
+				}
+				// only messages from the current opponent are delivered
+				if chatMsg.From != p.opponent.ID {
+					break
 				}
-				p.info.State = playerStateGameOver
 				p.WriteJSON(&message{
-					Type:    messageGameWon,
+					Type:    messageChat,
+					Payload: chatMsg,
+				})
+			case messageBerserk:
+				// the opponent is now playing against the clock
+				p.WriteJSON(&message{
+					Type:    messageBerserk,
 					Payload: payload,
 				})
-			case messagePlayerRestartGame:
-				p.CloseWaitingChan()
+			case messagePlayerResumed:
+				// the client reconnected with our session token, possibly on another node
+				if payload == p.session {
+					p.HandOff()
+				}
+			case messageRematch:
+				p.RematchOffered()
+			case messageAcceptRematch:
+				p.RematchAccepted()
+			case messageDeclineRematch:
+				p.RematchDeclined()
 			case messagePlayerExitGame:
 				p.ExitGame()
 			}
 		}
 	}
 }
+
+// SendPlayerJoined sends the client a player who joined the lobby, waiting for it to be saved if need be
+func (p *player) SendPlayerJoined(playerID string) {
+	// backoff
+	for i := 0; i < 5; i++ {
+		newPlayer := p.game.GetPlayer(playerID)
+		if newPlayer != nil {
+			p.WriteJSON(&message{
+				Type:    messagePlayerJoin,
+				Payload: newPlayer,
+			})
+			return
+		}
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
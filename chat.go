@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxChatLength = 200
+
+	// players may send this many chat messages within the window
+	chatRateLimit  = 5
+	chatRateWindow = 10 * time.Second
+)
+
+// words that are starred out of chat messages, along with their plurals and other common endings
+var profanities = []string{
+	"fuck", "shit", "bitch", "cunt", "asshole", "bastard", "dick", "cock", "pussy", "slut", "whore", "wanker",
+	"twat", "prick", "motherfucker", "bullshit",
+}
+
+var profanityRegexp = regexp.MustCompile(
+	`(?i)\b(?:` + strings.Join(profanities, "|") + `)(?:s|es|ed|er|ers|ing|y)?\b`,
+)
+
+// chatMessage is a message from one player to another
+type chatMessage struct {
+	From string
+	Name string
+	Text string
+	// unix time in milliseconds
+	Time int64
+}
+
+func getGameChatKey(id string) string {
+	return "game:" + id + ":chat"
+}
+
+func getChatRateKey(playerID string) string {
+	return "ratelimit:chat:" + playerID
+}
+
+// filterProfanity stars out the letters of profane words
+func filterProfanity(text string) string {
+	return profanityRegexp.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
+
+// validateChat checks the length of a chat message and returns it without surrounding space
+func validateChat(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", errors.New("chat message is empty")
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		return "", errors.Errorf("chat message may be at most %d characters long", maxChatLength)
+	}
+	return text, nil
+}
+
+// countMessageScript counts a message in the current window, which starts with the first message
+//
+// KEYS: rate limit key
+// ARGV: window in milliseconds
+var countMessageScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// allowMessage counts a message against the limit of a key for the current window. It reports whether the
+// limit has not been reached yet
+func allowMessage(redisClient *redis.Client, key string, limit int64, window time.Duration) (bool, error) {
+	n, err := countMessageScript.Run(redisClient, []string{key}, int64(window/time.Millisecond)).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to count message")
+	}
+	return n <= limit, nil
+}
+
+// Chat sends a message to the player's opponent and adds it to the game's chat log
+func (p *player) Chat(text string) error {
+	if p.opponent == nil || p.gameID == "" {
+		return errors.New("you can only chat during a game")
+	}
+	if p.info.State != playerStatePlaying && p.info.State != playerStateGameOver {
+		return errors.New("you can only chat during a game")
+	}
+	text, err := validateChat(text)
+	if err != nil {
+		return err
+	}
+	ok, err := allowMessage(p.redisClient, getChatRateKey(p.info.ID), chatRateLimit, chatRateWindow)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("you are sending messages too quickly")
+	}
+
+	msg := &chatMessage{
+		From: p.info.ID,
+		Name: p.info.Name,
+		Text: filterProfanity(text),
+		Time: millis(time.Now()),
+	}
+	bs, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode chat message")
+	}
+
+	// log the message with the game and forward it to the opponent in one transaction
+	_, err = p.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.RPush(getGameChatKey(p.gameID), string(bs))
+		pipe.Publish(p.opponent.ID, playerChat(string(bs)))
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to publish chat message")
+	}
+
+	// the sender sees the message as it was delivered
+	return p.WriteJSON(&message{Type: messageChat, Payload: msg})
+}
+
+// getGameChat returns the chat log of a game, oldest message first
+func getGameChat(redisClient *redis.Client, id string) ([]*chatMessage, error) {
+	entries, err := redisClient.LRange(getGameChatKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get game chat")
+	}
+	messages := make([]*chatMessage, 0, len(entries))
+	for _, entry := range entries {
+		msg := &chatMessage{}
+		err = json.Unmarshal([]byte(entry), msg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode chat message")
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// sendGameChat replies with the chat log of a game if the request's identity token belongs to one of its
+// players. Chat is private to the players, so it is not part of the game record
+func (g *game) sendGameChat(w http.ResponseWriter, r *http.Request, record *gameRecord) {
+	playerID, err := verifyIdentity(g.tokenSecret, identityToken(r))
+	if err != nil {
+		http.Error(w, "invalid identity token", http.StatusUnauthorized)
+		return
+	}
+	if playerID != record.PlayerX && playerID != record.PlayerO {
+		http.Error(w, "only the players of a game can read its chat", http.StatusForbidden)
+		return
+	}
+	chat, err := getGameChat(g.redisClient, record.ID)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, chat)
+}
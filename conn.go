@@ -2,24 +2,26 @@ package main
 
 import (
 	"fmt"
+	"strings"
 )
 
 func (p *player) ReadConn() {
-	var (
-		msg = new(message)
-		err error
-	)
-
 	for {
-		err = p.conn.ReadJSON(msg)
+		msg := new(message)
+		err := p.conn.ReadJSON(msg)
 		if err != nil {
-			// cancel game
+			// give the client some time to resume its game before cancelling it
+			p.HoldGame()
 			p.cancel()
 			break
 		}
 
-		// Process players request
-		p.HandleRequest(msg)
+		// requests are handled by ReadChannels along with everything else that changes the player
+		select {
+		case p.requests <- msg:
+		case <-p.ctx.Done():
+			return
+		}
 	}
 }
 
@@ -37,12 +39,13 @@ func (p *player) HandleRequest(msg *message) {
 	switch msg.Type {
 	case messagePlayerRequestGame: // STEP 1
 		// Example payload: REQUESTGAME playerID-wdjbdu938
-		playerID, ok := msg.Payload.(string)
-		if !ok {
-			errMsg := fmt.Sprintf("failed to convert %s payload to string", messagePlayerRequestGame)
-			p.WriteErrorString(errMsg)
+		// or REQUESTGAME {"Player": "playerID-wdjbdu938", "BestOf": 3}
+		req, err := parseChallengeRequest(msg.Payload)
+		if err != nil {
+			p.WriteError(err)
 			break
 		}
+		playerID := req.Player
 		// check that you are free
 		if p.info.State != playerStateFree {
 			p.WriteJSON(&message{
@@ -51,6 +54,14 @@ func (p *player) HandleRequest(msg *message) {
 			})
 			break
 		}
+		// a challenge to one of the server's bots in the lobby is played by a bot started for it
+		if level := strings.TrimPrefix(playerID, "bot#"); contains(botLevels, level) {
+			playerID, err = p.game.SpawnBot(level)
+			if err != nil {
+				p.WriteError(err)
+				break
+			}
+		}
 		// will wait
 		p.InitWaitingChan()
 		// update your state
@@ -62,6 +73,7 @@ func (p *player) HandleRequest(msg *message) {
 			break
 		}
 		p.opponent = opponent
+		p.settings = &req.gameSettings
 		// publish request on the opponent channel
 		err = p.WriteError(p.PublishRequestGame(playerID))
 		if err != nil {
@@ -82,89 +94,172 @@ func (p *player) HandleRequest(msg *message) {
 		p.Reset()
 	case messagePlayerAcceptGame: // STEP 3
 		// Example payload: ACCEPTGAME playerID-wdjbdu938
-		channelID, ok := msg.Payload.(string)
+		_, ok := msg.Payload.(string)
 		if !ok {
 			errMsg := fmt.Sprintf("failed to convert %s payload to string", messagePlayerStartGame)
 			p.WriteErrorString(errMsg)
 			break
 		}
+		// only a challenge made to us can be accepted, and only while it stands
+		if p.info.State != playerStateRequesting || !p.challenged || p.opponent == nil {
+			p.WriteErrorString("there is no challenge to accept")
+			break
+		}
+		gameID, err := newGameID()
+		if err != nil {
+			p.WriteError(err)
+			break
+		}
+		// the challenger is player A of a series
+		if p.settings != nil && p.settings.BestOf > 1 {
+			err = p.WriteError(createSeries(p.redisClient, gameID, p.settings.BestOf, p.opponent.ID, p.info.ID))
+			if err != nil {
+				break
+			}
+		}
 		// inform opponent to start game
-		err = p.WriteError(p.PublishStartGame(channelID))
+		err = p.WriteError(p.PublishStartGame(gameID))
 		if err != nil {
 			break
 		}
-		p.StartGame()
+		p.StartGame(gameID, markO)
 	case messagePlayerMove: // STEP 5
 		// Example payload: PLAYERMOVE box-33
+		// or, in ultimate tic-tac-toe, PLAYERMOVE {"Board": 5, "Cell": 3}
 		if p.info.State != playerStatePlaying {
 			break
 		}
-		moveID, ok := msg.Payload.(string)
-		if !ok {
-			errMsg := fmt.Sprintf("failed to convert %s payload to string", messagePlayerMove)
-			p.WriteErrorString(errMsg)
+		moveID, err := p.board.ParseMove(msg.Payload)
+		if err != nil {
+			p.WriteError(err)
 			break
 		}
-		// publish the move on opponent channel
-		p.WriteError(p.PublishMove(moveID))
-	case messageGameDraw: // STEP 7
-		// Example payload: DRAW
-		p.InitWaitingChan()
-		_, ok := msg.Payload.(string)
+		// validate the move then publish it on opponent channel
+		p.WriteError(p.PlayMove(moveID))
+	case messageGameDraw, messageGameWon: // STEP 7
+		// Example payload: DRAW or WON winnerID
+		// results are worked out from the board once the last move is played; claims from clients are ignored
+	case messageRematch, messagePlayerRestartGame: // STEP 8
+		// Example payload: REMATCH
+		// the opponent's answer comes as ACCEPTREMATCH or DECLINEREMATCH; an offer it already made is accepted
+		p.WriteError(p.OfferRematch())
+	case messageAcceptRematch:
+		// Example payload: ACCEPTREMATCH
+		p.WriteError(p.AcceptRematch())
+	case messageDeclineRematch:
+		// Example payload: DECLINEREMATCH
+		p.WriteError(p.DeclineRematch())
+	case messagePlayerExitGame:
+		p.ExitGameAndPublish()
+	case messagePlayerSetName:
+		// Example payload: SETNAME Flying Cobra
+		name, ok := msg.Payload.(string)
 		if !ok {
-			errMsg := fmt.Sprintf("failed to convert %s payload to string", messageGameDraw)
+			errMsg := fmt.Sprintf("failed to convert %s payload to string", messagePlayerSetName)
 			p.WriteErrorString(errMsg)
 			break
 		}
-		// publish the draw on opponent channel
-		err = p.WriteError(p.PublishGameDraw())
+		p.WriteError(p.SetName(name))
+	case messagePlayerFindGame:
+		// Example payload: FINDGAME
+		p.WriteError(p.JoinQueue())
+	case messagePlayerCancelFind:
+		// Example payload: CANCELFIND
+		err = p.WriteError(p.LeaveQueue())
 		if err != nil {
 			break
 		}
-		p.WriteError(p.redisClient.HIncrBy(p.info.ID, "draw", 1).Err())
-		p.info.State = playerStateGameOver
-		p.info.Draw++
-	case messageGameWon:
-		// Example payload: WON winnerID
-		p.InitWaitingChan()
-		playerID, ok := msg.Payload.(string)
+		p.WriteJSON(&message{
+			Type:    messagePlayerCancelFind,
+			Payload: "",
+		})
+	case messageCreateRoom:
+		// Example payload: CREATEROOM
+		p.WriteError(p.OpenRoom())
+	case messageJoinRoom:
+		// Example payload: JOINROOM K7QX2M
+		code, ok := msg.Payload.(string)
 		if !ok {
-			errMsg := fmt.Sprintf("failed to convert %s payload to string", messageGameWon)
+			errMsg := fmt.Sprintf("failed to convert %s payload to string", messageJoinRoom)
 			p.WriteErrorString(errMsg)
 			break
 		}
-		// publish the won on opponent channel
-		err = p.WriteError(p.PublishGameWon(playerID))
+		p.WriteError(p.JoinRoom(code))
+	case messageCloseRoom:
+		// Example payload: CLOSEROOM
+		err = p.WriteError(p.CloseRoom())
 		if err != nil {
 			break
 		}
-		if playerID == p.info.ID {
-			// i won
-			err = p.WriteError(p.redisClient.HIncrBy(p.info.ID, "won", 1).Err())
-			if err != nil {
-				break
-			}
-			p.info.Won++
-		} else {
-			// i lost
-			err = p.WriteError(p.redisClient.HIncrBy(p.info.ID, "lost", 1).Err())
+		p.WriteJSON(&message{
+			Type:    messageCloseRoom,
+			Payload: "",
+		})
+	case messageLeaderboard:
+		// Example payload: LEADERBOARD {"Board": "wins", "Window": "weekly", "Page": 1, "Size": 20}
+		q := &leaderboardQuery{}
+		if msg.Payload != nil {
+			err = decodePayload(msg.Payload, q)
 			if err != nil {
+				p.WriteError(err)
 				break
 			}
-			p.info.Lost++
 		}
-		p.info.State = playerStateGameOver
-	case messagePlayerRestartGame: // STEP 8
-		// Example payload: RESTARTGAME
-		err = p.WriteError(p.PublishGameRestart())
+		p.WriteError(p.SendLeaderboard(q))
+	case messageChat:
+		// Example payload: CHAT good luck!
+		text, ok := msg.Payload.(string)
+		if !ok {
+			errMsg := fmt.Sprintf("failed to convert %s payload to string", messageChat)
+			p.WriteErrorString(errMsg)
+			break
+		}
+		p.WriteError(p.Chat(text))
+	case messageLobbyChat:
+		// Example payload: LOBBYCHAT anyone up for a game?
+		text, ok := msg.Payload.(string)
+		if !ok {
+			errMsg := fmt.Sprintf("failed to convert %s payload to string", messageLobbyChat)
+			p.WriteErrorString(errMsg)
+			break
+		}
+		p.WriteError(p.LobbyChat(text))
+	case messageLobbyDelete:
+		// Example payload: LOBBYDELETE 42
+		id, err := parseLobbyMessageID(msg.Payload)
 		if err != nil {
+			p.WriteError(err)
 			break
 		}
-		// update state of player
-		p.info.State = playerStateGameOver
-		// time player for 10 sec
-		go p.TimeOperation(p.RestartGame, p.ExitGameAndPublish)
-	case messagePlayerExitGame:
-		p.ExitGameAndPublish()
+		p.WriteError(p.DeleteLobbyMessage(id))
+	case messageLobbyMute:
+		// Example payload: LOBBYMUTE {"Player": "player#9f2c41d07ab3e5f6", "Minutes": 60}
+		mute := &lobbyMute{}
+		err = decodePayload(msg.Payload, mute)
+		if err != nil {
+			p.WriteError(err)
+			break
+		}
+		p.WriteError(p.MutePlayer(mute))
+	case messageBerserk:
+		// Example payload: BERSERK
+		p.WriteError(p.Berserk())
+	case messageJoinTournament, messageLeaveTournament, messageTournament:
+		// Example payload: JOINTOURNAMENT 5b1f0c2a9d3e
+		tournamentID, ok := msg.Payload.(string)
+		if !ok {
+			errMsg := fmt.Sprintf("failed to convert %s payload to string", msg.Type)
+			p.WriteErrorString(errMsg)
+			break
+		}
+		switch msg.Type {
+		case messageJoinTournament:
+			p.WriteError(p.JoinTournament(tournamentID))
+		case messageLeaveTournament:
+			p.WriteError(p.LeaveTournament(tournamentID))
+		default:
+			// follow the standings of a tournament without playing in it
+			p.WriteError(p.FollowTournament(tournamentID))
+		}
 	}
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	connectFourRows      = 6
+	connectFourColumns   = 7
+	connectFourWinLength = 4
+
+	// connect four moves are stored as drop-<column> with columns numbered 1 to 7 from the left e.g drop-4
+	connectFourMovePrefix = "drop-"
+
+	moveErrorColumnFull = "COLUMN_FULL"
+)
+
+// connectFourMove is the payload of a PLAYERMOVE in Connect Four.
+// Example payload: PLAYERMOVE {"Column": 4}
+type connectFourMove struct {
+	Column int
+}
+
+// connectFourState is a snapshot of a Connect Four board as seen by one of the players
+type connectFourState struct {
+	Mark   string
+	Turn   string
+	Moves  int
+	Over   bool
+	Winner string
+	// rows from the top
+	Cells [connectFourRows][connectFourColumns]string
+}
+
+// connectFourBoard is Connect Four: marks are dropped into columns and fall to the lowest empty row. Four marks
+// in a row across, down or diagonally win
+type connectFourBoard struct {
+	mu      sync.Mutex // guards all fields below
+	cells   [connectFourRows][connectFourColumns]string
+	heights [connectFourColumns]int
+	turn    string
+	moves   int
+	winner  string
+}
+
+func newConnectFourBoard() *connectFourBoard {
+	return &connectFourBoard{turn: markX}
+}
+
+func formatConnectFourMoveID(col int) string {
+	return connectFourMovePrefix + strconv.Itoa(col+1)
+}
+
+// parseConnectFourMoveID returns the index of the column of a move
+func parseConnectFourMoveID(moveID string) (int, error) {
+	if !strings.HasPrefix(moveID, connectFourMovePrefix) {
+		return 0, newMoveError(moveErrorMalformed, moveID, `expected move of the form {"Column": 4}`)
+	}
+	col, err := strconv.Atoi(moveID[len(connectFourMovePrefix):])
+	if err != nil {
+		return 0, newMoveError(moveErrorMalformed, moveID, "column is not a number")
+	}
+	if col < 1 || col > connectFourColumns {
+		return 0, newMoveError(
+			moveErrorOutOfRange, moveID, fmt.Sprintf("column must be between 1 and %d", connectFourColumns),
+		)
+	}
+	return col - 1, nil
+}
+
+// ParseMove reads a structured move, a column number or a move id into a move id
+func (b *connectFourBoard) ParseMove(payload interface{}) (string, error) {
+	var moveID string
+	switch v := payload.(type) {
+	case string:
+		moveID = v
+	case float64:
+		moveID = connectFourMovePrefix + strconv.Itoa(int(v))
+	default:
+		move := &connectFourMove{}
+		err := decodePayload(payload, move)
+		if err != nil {
+			return "", newMoveError(moveErrorMalformed, fmt.Sprint(payload), `expected move of the form {"Column": 4}`)
+		}
+		moveID = connectFourMovePrefix + strconv.Itoa(move.Column)
+	}
+	_, err := parseConnectFourMoveID(moveID)
+	return moveID, err
+}
+
+// MovePayload returns the structured move
+func (b *connectFourBoard) MovePayload(moveID string) interface{} {
+	col, err := parseConnectFourMoveID(moveID)
+	if err != nil {
+		return moveID
+	}
+	return &connectFourMove{Column: col + 1}
+}
+
+// Check validates that mark can play moveID without changing the board
+func (b *connectFourBoard) Check(mark, moveID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.check(mark, moveID)
+	return err
+}
+
+func (b *connectFourBoard) check(mark, moveID string) (int, error) {
+	col, err := parseConnectFourMoveID(moveID)
+	if err != nil {
+		return 0, err
+	}
+	if b.over() {
+		return 0, newMoveError(moveErrorGameOver, moveID, "game is already over")
+	}
+	if b.turn != mark {
+		return 0, newMoveError(moveErrorNotYourTurn, moveID, "it is not your turn")
+	}
+	if b.heights[col] == connectFourRows {
+		return 0, newMoveError(moveErrorColumnFull, moveID, "column is full")
+	}
+	return col, nil
+}
+
+func (b *connectFourBoard) apply(mark string, col int) {
+	pos := position{row: connectFourRows - 1 - b.heights[col], col: col}
+	b.cells[pos.row][pos.col] = mark
+	b.heights[col]++
+	b.turn = opponentMark(mark)
+	b.moves++
+	for _, dir := range lineDirections {
+		n := 1 + b.run(mark, pos, dir.row, dir.col) + b.run(mark, pos, -dir.row, -dir.col)
+		if n >= connectFourWinLength {
+			b.winner = mark
+			return
+		}
+	}
+}
+
+// run counts the marks in a row from pos, not counting pos itself, going in the given direction
+func (b *connectFourBoard) run(mark string, pos position, dRow, dCol int) int {
+	n := 0
+	row, col := pos.row+dRow, pos.col+dCol
+	for row >= 0 && row < connectFourRows && col >= 0 && col < connectFourColumns && b.cells[row][col] == mark {
+		n++
+		row, col = row+dRow, col+dCol
+	}
+	return n
+}
+
+func (b *connectFourBoard) over() bool {
+	return b.winner != markNone || b.moves == connectFourRows*connectFourColumns
+}
+
+// Play validates and applies moveID for mark
+func (b *connectFourBoard) Play(mark, moveID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	col, err := b.check(mark, moveID)
+	if err != nil {
+		return err
+	}
+	b.apply(mark, col)
+	return nil
+}
+
+// Replay plays moves in order starting with X
+func (b *connectFourBoard) Replay(moves []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, moveID := range moves {
+		col, err := b.check(b.turn, moveID)
+		if err != nil {
+			return err
+		}
+		b.apply(b.turn, col)
+	}
+	return nil
+}
+
+// LegalMoves returns the columns that are not full yet
+func (b *connectFourBoard) LegalMoves() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	moves := make([]string, 0, connectFourColumns)
+	if b.over() {
+		return moves
+	}
+	for col := 0; col < connectFourColumns; col++ {
+		if b.heights[col] < connectFourRows {
+			moves = append(moves, formatConnectFourMoveID(col))
+		}
+	}
+	return moves
+}
+
+// Result returns the winning mark, if any, and whether the game is over. A full board without winner is a draw
+func (b *connectFourBoard) Result() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.winner, b.over()
+}
+
+// Turn returns the mark that plays next
+func (b *connectFourBoard) Turn() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.turn
+}
+
+// Moves returns the number of moves played
+func (b *connectFourBoard) Moves() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.moves
+}
+
+// State returns a snapshot of the board for the player with the given mark
+func (b *connectFourBoard) State(mark string) interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &connectFourState{
+		Mark:   mark,
+		Turn:   b.turn,
+		Moves:  b.moves,
+		Over:   b.over(),
+		Winner: b.winner,
+		Cells:  b.cells,
+	}
+}
+
+// Clone returns a copy of the board
+func (b *connectFourBoard) Clone() gameBoard {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &connectFourBoard{
+		cells:   b.cells,
+		heights: b.heights,
+		turn:    b.turn,
+		moves:   b.moves,
+		winner:  b.winner,
+	}
+}
+
+// restore returns a board with the cells and turn of the snapshot
+func (s *connectFourState) restore() (gameBoard, string) {
+	b := &connectFourBoard{
+		cells:  s.Cells,
+		turn:   s.Turn,
+		moves:  s.Moves,
+		winner: s.Winner,
+	}
+	for col := 0; col < connectFourColumns; col++ {
+		for row := 0; row < connectFourRows; row++ {
+			if s.Cells[row][col] != markNone {
+				b.heights[col]++
+			}
+		}
+	}
+	return b, s.Mark
+}
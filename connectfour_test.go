@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+)
+
+// dropColumns returns the moves that drop marks into the given columns, numbered from 1, in turn
+func dropColumns(cols ...int) []string {
+	moves := make([]string, 0, len(cols))
+	for _, col := range cols {
+		moves = append(moves, formatConnectFourMoveID(col-1))
+	}
+	return moves
+}
+
+func TestConnectFourResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		moves  []string
+		winner string
+		over   bool
+	}{
+		{
+			name:   "across",
+			moves:  dropColumns(1, 1, 2, 2, 3, 3, 4),
+			winner: markX,
+			over:   true,
+		},
+		{
+			name:   "down",
+			moves:  dropColumns(1, 2, 1, 2, 1, 2, 7, 2),
+			winner: markO,
+			over:   true,
+		},
+		{
+			name:   "diagonal up to the right",
+			moves:  dropColumns(1, 2, 2, 3, 4, 3, 3, 4, 5, 4, 4),
+			winner: markX,
+			over:   true,
+		},
+		{
+			name:   "diagonal up to the left",
+			moves:  dropColumns(7, 6, 6, 5, 4, 5, 5, 4, 3, 4, 4),
+			winner: markX,
+			over:   true,
+		},
+		{
+			name:   "across split by the other mark",
+			moves:  dropColumns(1, 3, 2, 7, 4, 7, 5),
+			winner: markNone,
+			over:   false,
+		},
+		{
+			name:   "three down",
+			moves:  dropColumns(1, 2, 1, 2, 1),
+			winner: markNone,
+			over:   false,
+		},
+		{
+			name: "full board",
+			moves: dropColumns(
+				1, 2, 1, 2, 1, 2, 2, 1, 2, 1, 2, 1,
+				3, 4, 3, 4, 3, 4, 4, 3, 4, 3, 4, 3,
+				5, 6, 5, 6, 5, 6, 6, 5, 6, 5, 6, 5,
+				7, 7, 7, 7, 7, 7,
+			),
+			winner: markNone,
+			over:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newConnectFourBoard()
+			err := b.Replay(tt.moves)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			winner, over := b.Result()
+			if winner != tt.winner || over != tt.over {
+				t.Errorf("Result() = %q, %v, want %q, %v", winner, over, tt.winner, tt.over)
+			}
+		})
+	}
+}
+
+func TestConnectFourGravity(t *testing.T) {
+	b := newConnectFourBoard()
+	err := b.Replay(dropColumns(4, 4, 4))
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	want := []string{markX, markO, markX}
+	for i, mark := range want {
+		row := connectFourRows - 1 - i
+		if b.cells[row][3] != mark {
+			t.Errorf("row %d of column 4 = %q, want %q", row+1, b.cells[row][3], mark)
+		}
+	}
+	if b.cells[connectFourRows-1-len(want)][3] != markNone {
+		t.Errorf("column 4 holds more marks than were dropped")
+	}
+}
+
+func TestConnectFourPlay(t *testing.T) {
+	tests := []struct {
+		name   string
+		moves  []string
+		mark   string
+		moveID string
+		code   string
+	}{
+		{
+			name:   "into an empty column",
+			mark:   markX,
+			moveID: "drop-4",
+		},
+		{
+			name:   "onto a mark",
+			moves:  dropColumns(4),
+			mark:   markO,
+			moveID: "drop-4",
+		},
+		{
+			name:   "into a full column",
+			moves:  dropColumns(4, 4, 4, 4, 4, 4),
+			mark:   markX,
+			moveID: "drop-4",
+			code:   moveErrorColumnFull,
+		},
+		{
+			name:   "not your turn",
+			mark:   markO,
+			moveID: "drop-1",
+			code:   moveErrorNotYourTurn,
+		},
+		{
+			name:   "out of range",
+			mark:   markX,
+			moveID: "drop-8",
+			code:   moveErrorOutOfRange,
+		},
+		{
+			name:   "malformed",
+			mark:   markX,
+			moveID: "box-11",
+			code:   moveErrorMalformed,
+		},
+		{
+			name:   "game over",
+			moves:  dropColumns(1, 1, 2, 2, 3, 3, 4),
+			mark:   markO,
+			moveID: "drop-5",
+			code:   moveErrorGameOver,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newConnectFourBoard()
+			err := b.Replay(tt.moves)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			err = b.Play(tt.mark, tt.moveID)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("Play() error = %v", err)
+				}
+				return
+			}
+			moveErr, ok := err.(*moveError)
+			if !ok || moveErr.Code != tt.code {
+				t.Errorf("Play() error = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
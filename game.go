@@ -6,6 +6,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"net/http"
+	"time"
 )
 
 type game struct {
@@ -15,17 +16,58 @@ type game struct {
 	waitChan       chan struct{}
 	playersNum     int
 	freePlayersNum int
+	resumeGrace    time.Duration
+	tokenSecret    []byte
+	bots           []string
+	botDeadline    time.Duration
+	botSignupKey   string
+	organizerKey   string
 }
 
-func newGame(redisClient *redis.Client) (*game, error) {
+type gameOptions struct {
+	// how long a game is held open after a player loses their connection
+	ResumeGrace time.Duration
+	// key used to sign identity tokens; must be the same on all nodes
+	TokenSecret string
+	// difficulty levels of the bots to run; each bot runs on one node of the cluster at a time
+	Bots []string
+	// how long a bot has to make its move before it forfeits the game
+	BotMoveDeadline time.Duration
+	// key required to register external bots; bots cannot be registered if it is empty
+	BotRegistrationKey string
+	// key required to create and start tournaments; tournaments cannot be organized if it is empty
+	OrganizerKey string
+}
+
+func newGame(redisClient *redis.Client, opts *gameOptions) (*game, error) {
 	if redisClient == nil {
 		return nil, errors.New("nil redis client")
 	}
+	if opts == nil {
+		return nil, errors.New("nil game options")
+	}
+	if opts.TokenSecret == "" {
+		return nil, errors.New("empty token secret")
+	}
+	if opts.BotMoveDeadline <= 0 {
+		return nil, errors.New("bot move deadline must be positive")
+	}
+	for _, level := range opts.Bots {
+		if !contains(botLevels, level) {
+			return nil, errors.Errorf("unknown bot level %q", level)
+		}
+	}
 	g := &game{
-		redisClient: redisClient,
-		newPlayer:   &playerInfo{},
-		freePlayers: make(map[string]*playerInfo, 0),
-		waitChan:    make(chan struct{}, 0),
+		redisClient:  redisClient,
+		resumeGrace:  opts.ResumeGrace,
+		tokenSecret:  []byte(opts.TokenSecret),
+		bots:         opts.Bots,
+		botDeadline:  opts.BotMoveDeadline,
+		botSignupKey: opts.BotRegistrationKey,
+		organizerKey: opts.OrganizerKey,
+		newPlayer:    &playerInfo{},
+		freePlayers:  make(map[string]*playerInfo, 0),
+		waitChan:     make(chan struct{}, 0),
 	}
 
 	// get 500 latest players from redis sorted set
@@ -51,6 +93,11 @@ func newGame(redisClient *redis.Client) (*game, error) {
 
 	// run game
 	go g.run()
+	go g.matchmake()
+	go g.runTournaments()
+	for _, level := range g.bots {
+		go g.superviseBot(level)
+	}
 
 	return g, nil
 }
@@ -89,6 +136,17 @@ func (g *game) run() {
 			delete(g.freePlayers, payload)
 			g.playersNum--
 			g.freePlayersNum--
+		case messagePlayerNameChanged:
+			freePlayer, ok := g.freePlayers[payload]
+			if !ok {
+				break
+			}
+			p, err := getPlayerFromRedis(g.redisClient, payload)
+			if err != nil {
+				logrus.Errorln(err)
+				break
+			}
+			freePlayer.Name = p.Name
 		}
 	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+)
+
+// Glicko-2 rating system as described in http://www.glicko.net/glicko/glicko2.pdf.
+// Every game is treated as a rating period of its own.
+const (
+	defaultRating     = 1500.0
+	defaultDeviation  = 350.0
+	defaultVolatility = 0.06
+
+	// constrains the change in volatility over time
+	glickoTau = 0.5
+	// convergence tolerance of the volatility iteration
+	glickoEpsilon = 0.000001
+	// converts between the glicko and glicko-2 scales
+	glickoScale = 173.7178
+
+	// game scores
+	scoreWin  = 1.0
+	scoreDraw = 0.5
+	scoreLoss = 0.0
+)
+
+type rating struct {
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+}
+
+// ratingChange is an entry in a player's rating history
+type ratingChange struct {
+	GameID     string
+	Rating     float64
+	Deviation  float64
+	Volatility float64
+	Time       int64
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// updateRating returns the rating of player after a game against opponent with the given score
+func updateRating(player, opponent *rating, score float64) *rating {
+	// step 2: convert to the glicko-2 scale
+	mu := (player.Rating - defaultRating) / glickoScale
+	phi := player.Deviation / glickoScale
+	sigma := player.Volatility
+	muJ := (opponent.Rating - defaultRating) / glickoScale
+	phiJ := opponent.Deviation / glickoScale
+
+	// steps 3 and 4: estimated variance and improvement
+	g := glickoG(phiJ)
+	e := 1 / (1 + math.Exp(-g*(mu-muJ)))
+	v := 1 / (g * g * e * (1 - e))
+	delta := v * g * (score - e)
+
+	// step 5: new volatility
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB < 0 {
+			A, fA = B, fB
+		} else {
+			fA = fA / 2
+		}
+		B, fB = C, fC
+	}
+	sigmaNew := math.Exp(A / 2)
+
+	// steps 6 and 7: new deviation and rating
+	phiStar := math.Sqrt(phi*phi + sigmaNew*sigmaNew)
+	phiNew := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	muNew := mu + phiNew*phiNew*g*(score-e)
+
+	// step 8: convert back to the glicko scale
+	return &rating{
+		Rating:     muNew*glickoScale + defaultRating,
+		Deviation:  math.Min(phiNew*glickoScale, defaultDeviation),
+		Volatility: sigmaNew,
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUpdateRating(t *testing.T) {
+	newcomer := &rating{Rating: defaultRating, Deviation: defaultDeviation, Volatility: defaultVolatility}
+	settled := &rating{Rating: defaultRating, Deviation: 50, Volatility: defaultVolatility}
+
+	tests := []struct {
+		name     string
+		player   *rating
+		opponent *rating
+		score    float64
+		// the new rating is compared with the old one: 1 above, -1 below, 0 within a point
+		change int
+	}{
+		{name: "win against an equal", player: newcomer, opponent: newcomer, score: scoreWin, change: 1},
+		{name: "loss against an equal", player: newcomer, opponent: newcomer, score: scoreLoss, change: -1},
+		{name: "draw against an equal", player: newcomer, opponent: newcomer, score: scoreDraw, change: 0},
+		{
+			name:     "draw against a stronger player",
+			player:   newcomer,
+			opponent: &rating{Rating: 1900, Deviation: 100, Volatility: defaultVolatility},
+			score:    scoreDraw,
+			change:   1,
+		},
+		{
+			name:     "draw against a weaker player",
+			player:   newcomer,
+			opponent: &rating{Rating: 1100, Deviation: 100, Volatility: defaultVolatility},
+			score:    scoreDraw,
+			change:   -1,
+		},
+		{name: "win of a settled player", player: settled, opponent: newcomer, score: scoreWin, change: 1},
+		{name: "loss of a settled player", player: settled, opponent: newcomer, score: scoreLoss, change: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := updateRating(tt.player, tt.opponent, tt.score)
+			diff := r.Rating - tt.player.Rating
+			switch {
+			case tt.change > 0 && diff <= 1:
+				t.Errorf("rating = %.2f, want above %.2f", r.Rating, tt.player.Rating)
+			case tt.change < 0 && diff >= -1:
+				t.Errorf("rating = %.2f, want below %.2f", r.Rating, tt.player.Rating)
+			case tt.change == 0 && math.Abs(diff) > 1:
+				t.Errorf("rating = %.2f, want about %.2f", r.Rating, tt.player.Rating)
+			}
+			if r.Deviation <= 0 || r.Deviation > defaultDeviation {
+				t.Errorf("deviation = %.2f, want between 0 and %.0f", r.Deviation, defaultDeviation)
+			}
+			if r.Volatility <= 0 || math.Abs(r.Volatility-tt.player.Volatility) > 0.01 {
+				t.Errorf("volatility = %.5f, want about %.5f", r.Volatility, tt.player.Volatility)
+			}
+		})
+	}
+}
+
+func TestUpdateRatingDeviation(t *testing.T) {
+	// a settled player's rating moves less than a newcomer's and every game makes the rating more certain
+	newcomer := &rating{Rating: defaultRating, Deviation: defaultDeviation, Volatility: defaultVolatility}
+	settled := &rating{Rating: defaultRating, Deviation: 50, Volatility: defaultVolatility}
+
+	fresh := updateRating(newcomer, newcomer, scoreWin)
+	old := updateRating(settled, newcomer, scoreWin)
+	if fresh.Rating-newcomer.Rating <= old.Rating-settled.Rating {
+		t.Errorf("newcomer gained %.2f, settled player %.2f; want the newcomer to gain more",
+			fresh.Rating-newcomer.Rating, old.Rating-settled.Rating)
+	}
+	if fresh.Deviation >= newcomer.Deviation {
+		t.Errorf("deviation = %.2f, want below %.2f", fresh.Deviation, newcomer.Deviation)
+	}
+}
+
+func TestUpdateRatingSymmetric(t *testing.T) {
+	// between two players with the same deviation, one gains what the other loses
+	a := &rating{Rating: 1620, Deviation: 120, Volatility: defaultVolatility}
+	b := &rating{Rating: 1480, Deviation: 120, Volatility: defaultVolatility}
+
+	for _, score := range []float64{scoreWin, scoreDraw, scoreLoss} {
+		newA := updateRating(a, b, score)
+		newB := updateRating(b, a, 1-score)
+		gain, loss := newA.Rating-a.Rating, b.Rating-newB.Rating
+		if math.Abs(gain-loss) > 0.01 {
+			t.Errorf("score %.1f: gained %.2f, lost %.2f", score, gain, loss)
+		}
+	}
+}
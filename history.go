@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultGamesLimit = 20
+	maxGamesLimit     = 100
+)
+
+// ListGames returns a page of a player's games, newest first.
+// Example: GET /games?player=player%23127.0.0.1&offset=0&limit=20
+func (g *game) ListGames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+
+	playerID := query.Get("player")
+	if playerID == "" {
+		http.Error(w, "missing player query parameter", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset must be zero or more", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), defaultGamesLimit)
+	if err != nil || limit <= 0 || limit > maxGamesLimit {
+		http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxGamesLimit), http.StatusBadRequest)
+		return
+	}
+
+	games, err := getPlayerGames(g.redisClient, playerID, offset, limit)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, games)
+}
+
+// ListLiveGames returns a page of the games being played that can be watched, newest first.
+// Example: GET /games/live?offset=0&limit=20
+func (g *game) ListLiveGames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset must be zero or more", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), defaultGamesLimit)
+	if err != nil || limit <= 0 || limit > maxGamesLimit {
+		http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxGamesLimit), http.StatusBadRequest)
+		return
+	}
+
+	games, err := getLiveGames(g.redisClient, offset, limit)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, games)
+}
+
+// GetGame returns a single game with its ordered list of moves so that it can be replayed. X always moves first.
+// The chat of a game is only returned to its players, identified by the request's identity token.
+// Example: GET /games/9f2c41d07ab3e5f6
+// Example: GET /games/9f2c41d07ab3e5f6/chat with an X-Identity-Token: cGxheWVy... header
+func (g *game) GetGame(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/games/"), "/")
+	gameID := path[0]
+	if gameID == "" {
+		http.Error(w, "missing game id", http.StatusBadRequest)
+		return
+	}
+	if len(path) > 2 || (len(path) == 2 && path[1] != "chat") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	exist, err := g.redisClient.Exists(getGameKey(gameID)).Result()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exist == 0 {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+
+	record, err := getGameFromRedis(g.redisClient, gameID)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(path) == 2 {
+		g.sendGameChat(w, r, record)
+		return
+	}
+	writeJSON(w, record)
+}
+
+// ListRatings returns a page of a player's rating changes, newest first.
+// Example: GET /ratings?player=player%239f2c41d07ab3e5f6&offset=0&limit=20
+func (g *game) ListRatings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+
+	playerID := query.Get("player")
+	if playerID == "" {
+		http.Error(w, "missing player query parameter", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "offset must be zero or more", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), defaultGamesLimit)
+	if err != nil || limit <= 0 || limit > maxGamesLimit {
+		http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxGamesLimit), http.StatusBadRequest)
+		return
+	}
+
+	changes, err := getRatingHistory(g.redisClient, playerID, offset, limit)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, changes)
+}
+
+func queryInt(value string, defaultValue int64) (int64, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	return i, errors.Wrap(err, "failed to convert query value to int")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	logError(err)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	identityCookie = "xo_identity"
+	identityMaxAge = 365 * 24 * time.Hour
+	// clients that do not keep cookies send their identity token in this header instead
+	identityTokenHeader = "X-Identity-Token"
+
+	// ids of players that were known by ip address before identity tokens and have been claimed by a client
+	migratedPlayersSet = "set:players:migrated"
+)
+
+// signIdentity returns a token that proves the holder is playerID.
+// Example: cGxheWVyIzlmMmM0MWQwN2FiM2U1ZjY.4mX0c3...
+func signIdentity(secret []byte, playerID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(playerID))
+	return base64.RawURLEncoding.EncodeToString([]byte(playerID)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyIdentity returns the player id of a token created by signIdentity
+func verifyIdentity(secret []byte, token string) (string, error) {
+	ss := strings.Split(token, ".")
+	if len(ss) != 2 {
+		return "", errors.New("malformed identity token")
+	}
+	playerID, err := base64.RawURLEncoding.DecodeString(ss[0])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode identity token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(ss[1])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode identity token signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(playerID)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("invalid identity token signature")
+	}
+	return string(playerID), nil
+}
+
+func newPlayerID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate player id")
+	}
+	return "player#" + hex.EncodeToString(b), nil
+}
+
+// IdentifyPlayer returns the id of the player making the request together with its signed identity token.
+// The token is read from the identity cookie or header; clients without one get a new identity.
+func (g *game) IdentifyPlayer(r *http.Request) (string, string, error) {
+	token := identityToken(r)
+	if token != "" {
+		playerID, err := verifyIdentity(g.tokenSecret, token)
+		if err == nil {
+			return playerID, token, nil
+		}
+		logError(err)
+	}
+
+	playerID, err := g.migratedPlayerID(r)
+	if err != nil {
+		return "", "", err
+	}
+	if playerID == "" {
+		playerID, err = newPlayerID()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return playerID, signIdentity(g.tokenSecret, playerID), nil
+}
+
+// identityToken returns the identity token sent with the request, if any. It is never taken from the url so
+// that it does not show up in access logs or browser history
+func identityToken(r *http.Request) string {
+	if cookie, err := r.Cookie(identityCookie); err == nil {
+		return cookie.Value
+	}
+	return r.Header.Get(identityTokenHeader)
+}
+
+// migratedPlayerID returns the id of the record keyed by the client's ip address if there is one that has not
+// been claimed yet. This lets players from before identity tokens keep their stats
+func (g *game) migratedPlayerID(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get client ip address")
+	}
+	legacyID := "player#" + host
+
+	i, err := g.redisClient.Exists(legacyID).Result()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to check for ip address player")
+	}
+	if i == 0 {
+		return "", nil
+	}
+
+	// only the first client to claim the record gets it
+	claimed, err := g.redisClient.SAdd(migratedPlayersSet, legacyID).Result()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to claim ip address player")
+	}
+	if claimed == 0 {
+		return "", nil
+	}
+	return legacyID, nil
+}
+
+func identityHeader(token string) http.Header {
+	cookie := &http.Cookie{
+		Name:     identityCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(identityMaxAge / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return http.Header{"Set-Cookie": []string{cookie.String()}}
+}
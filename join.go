@@ -2,15 +2,21 @@ package main
 
 import (
 	"context"
-	"github.com/Pallinder/go-randomdata"
 	"github.com/go-redis/redis"
-	"net"
 	"net/http"
 	"sync"
 	"time"
 )
 
 func (g *game) PlayerJoin(w http.ResponseWriter, r *http.Request) {
+	// a client that lost its connection resumes its game with the session token it was given
+	// Example: /ws?session=5b1f0c...
+	if token := r.URL.Query().Get("session"); token != "" {
+		if g.ResumePlayer(w, r, token) {
+			return
+		}
+	}
+
 	ctx, cancel := context.WithCancel(r.Context())
 
 	p := &player{
@@ -20,19 +26,20 @@ func (g *game) PlayerJoin(w http.ResponseWriter, r *http.Request) {
 		game:        g,
 		redisClient: g.redisClient,
 		waitingChan: make(chan struct{}, 0),
+		handedOff:   make(chan struct{}, 0),
+		requests:    make(chan *message),
+		events:      make(chan func()),
 		info:        &playerInfo{},
 	}
 
-	// get user ip address
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	// identify the player from its token or give it a new identity
+	playerID, token, err := g.IdentifyPlayer(r)
 	if err != nil {
 		logError(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	playerID := "player#" + host
-
 	// check if user has already joined the game and is in set
 	i, err := g.redisClient.Exists(playerID).Result()
 	if err != nil {
@@ -42,8 +49,6 @@ func (g *game) PlayerJoin(w http.ResponseWriter, r *http.Request) {
 	}
 	exist := i == 1
 
-	playerName := randomdata.SillyName()
-
 	// player exist in set
 	if exist {
 		// will update score
@@ -57,14 +62,6 @@ func (g *game) PlayerJoin(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// set name
-		err = p.redisClient.HSet(playerID, "name", playerName).Err()
-		if err != nil {
-			logError(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		// get player from set
 		p.info, err = getPlayerFromRedis(g.redisClient, playerID)
 		if err != nil {
@@ -76,12 +73,14 @@ func (g *game) PlayerJoin(w http.ResponseWriter, r *http.Request) {
 
 	if !exist {
 		p.info = &playerInfo{
-			Name:  playerName,
-			ID:    playerID,
-			State: playerStateFree,
-			Won:   0,
-			Draw:  0,
-			Lost:  0,
+			ID:               playerID,
+			State:            playerStateFree,
+			Won:              0,
+			Draw:             0,
+			Lost:             0,
+			Rating:           defaultRating,
+			RatingDeviation:  defaultDeviation,
+			RatingVolatility: defaultVolatility,
 		}
 		// add player to distributed cache
 		err := saveInCache(p.info, p.redisClient)
@@ -92,16 +91,29 @@ func (g *game) PlayerJoin(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	p.info.Name = playerName
+	// players keep their name across sessions; new players and players without a valid name get a random one
+	p.info.Name, err = assignName(g.redisClient, playerID, p.info.Name)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// upgrade connection to websocket
-	p.conn, err = upgrader.Upgrade(w, r, nil)
+	// upgrade connection to websocket, storing the identity token in a cookie
+	p.conn, err = upgrader.Upgrade(w, r, identityHeader(token))
 	if err != nil {
 		logError(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	err = p.Subscribe()
+	if err != nil {
+		logError(err)
+		p.WriteError(err)
+		return
+	}
+
 	err = p.WriteError(p.JoinGame())
 	if err != nil {
 		logError(err)
@@ -111,21 +123,48 @@ func (g *game) PlayerJoin(w http.ResponseWriter, r *http.Request) {
 
 	p.Reset()
 
+	err = p.NewSession()
+	if err != nil {
+		logError(err)
+		p.WriteError(err)
+		return
+	}
+
 	// we send information about player
 	err = p.WriteJSON(&message{Type: messageWelcome, Payload: p.info})
 	if err != nil {
 		return
 	}
 
+	// we send the identity token for clients that do not keep cookies
+	err = p.WriteJSON(&message{Type: messageIdentity, Payload: token})
+	if err != nil {
+		return
+	}
+
+	// we send the token with which the player can resume its game after a disconnect
+	err = p.SendSession()
+	if err != nil {
+		return
+	}
+
 	// we send list of online players
 	err = p.conn.WriteJSON(&message{Type: messageAllPlayers, Payload: p.game.FreePlayers()})
 	if err != nil {
 		return
 	}
 
-	// handle all read/write events for this player
-	go p.ReadConn()
-	go p.ReadChannels()
-	<-p.ctx.Done()
-	logError(p.LeaveGame())
+	// we send the latest messages of the lobby chat
+	err = p.WriteError(p.SendLobbyChat())
+	if err != nil {
+		return
+	}
+
+	// players who follow an invite link join the private room straight away
+	// Example: /ws?room=K7QX2M
+	if code := r.URL.Query().Get("room"); code != "" {
+		p.WriteError(p.JoinRoom(code))
+	}
+
+	p.Run()
 }
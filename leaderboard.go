@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// leaderboards
+	leaderboardRating = "rating"
+	leaderboardWins   = "wins"
+	leaderboardStreak = "streak"
+
+	// leaderboard windows
+	windowAllTime = "all"
+	windowWeekly  = "weekly"
+	windowMonthly = "monthly"
+
+	// how long periodic leaderboards are kept after they were last updated
+	weeklyLeaderboardTTL  = 9 * 7 * 24 * time.Hour
+	monthlyLeaderboardTTL = 13 * 31 * 24 * time.Hour
+
+	defaultLeaderboardSize = 20
+	maxLeaderboardSize     = 100
+)
+
+var (
+	leaderboards       = []string{leaderboardRating, leaderboardWins, leaderboardStreak}
+	leaderboardWindows = []string{windowAllTime, windowWeekly, windowMonthly}
+)
+
+// leaderboardPeriod returns the period of window that t falls in e.g 2026-W42 for weekly and 2026-10 for monthly
+func leaderboardPeriod(window string, t time.Time) string {
+	switch window {
+	case windowWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case windowMonthly:
+		return t.Format("2006-01")
+	}
+	return ""
+}
+
+// getLeaderboardKey returns the sorted set of a leaderboard for a period of the window
+// Example: zset:leaderboard:wins:weekly:2026-W42
+func getLeaderboardKey(board, window, period string) string {
+	if window == windowAllTime {
+		return "zset:leaderboard:" + board
+	}
+	return "zset:leaderboard:" + board + ":" + window + ":" + period
+}
+
+// getLeaderboardKeys returns the keys of board for every window at time t; all-time first, then weekly and monthly
+func getLeaderboardKeys(board string, t time.Time) []string {
+	keys := make([]string, 0, len(leaderboardWindows))
+	for _, window := range leaderboardWindows {
+		keys = append(keys, getLeaderboardKey(board, window, leaderboardPeriod(window, t)))
+	}
+	return keys
+}
+
+// getStreaksKey returns the hash of the players' current winning streaks within a period of the window, from
+// which the window's streak leaderboard is kept. The all-time streak is kept with the player
+// Example: hash:streaks:weekly:2026-W42
+func getStreaksKey(window, period string) string {
+	return "hash:streaks:" + window + ":" + period
+}
+
+type leaderboardQuery struct {
+	Board  string
+	Window string
+	// period of a weekly or monthly window; defaults to the current one
+	Period string
+	Page   int64
+	Size   int64
+}
+
+type leaderboardEntry struct {
+	Rank   int64
+	Player string
+	Name   string
+	Score  float64
+}
+
+type leaderboardPage struct {
+	Board   string
+	Window  string
+	Period  string
+	Page    int64
+	Size    int64
+	Total   int64
+	Entries []*leaderboardEntry
+}
+
+// validate checks the query and fills in defaults
+func (q *leaderboardQuery) validate() error {
+	if q.Board == "" {
+		q.Board = leaderboardRating
+	}
+	if q.Window == "" {
+		q.Window = windowAllTime
+	}
+	if !contains(leaderboards, q.Board) {
+		return errors.Errorf("unknown leaderboard %q", q.Board)
+	}
+	if !contains(leaderboardWindows, q.Window) {
+		return errors.Errorf("unknown leaderboard window %q", q.Window)
+	}
+	if q.Period == "" {
+		q.Period = leaderboardPeriod(q.Window, time.Now())
+	}
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.Size == 0 {
+		q.Size = defaultLeaderboardSize
+	}
+	if q.Page < 1 {
+		return errors.New("page must be 1 or more")
+	}
+	if q.Size < 1 || q.Size > maxLeaderboardSize {
+		return errors.Errorf("size must be between 1 and %d", maxLeaderboardSize)
+	}
+	return nil
+}
+
+// getLeaderboard returns a page of a leaderboard, highest score first
+func getLeaderboard(redisClient *redis.Client, q *leaderboardQuery) (*leaderboardPage, error) {
+	err := q.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	key := getLeaderboardKey(q.Board, q.Window, q.Period)
+	start := (q.Page - 1) * q.Size
+
+	members, err := redisClient.ZRevRangeWithScores(key, start, start+q.Size-1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get leaderboard")
+	}
+	total, err := redisClient.ZCard(key).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get leaderboard size")
+	}
+
+	// get the names of the players in one round trip
+	names := make([]*redis.StringCmd, 0, len(members))
+	_, err = redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, member := range members {
+			names = append(names, pipe.HGet(member.Member.(string), "name"))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "failed to get leaderboard names")
+	}
+
+	page := &leaderboardPage{
+		Board:   q.Board,
+		Window:  q.Window,
+		Period:  q.Period,
+		Page:    q.Page,
+		Size:    q.Size,
+		Total:   total,
+		Entries: make([]*leaderboardEntry, 0, len(members)),
+	}
+	for i, member := range members {
+		page.Entries = append(page.Entries, &leaderboardEntry{
+			Rank:   start + int64(i) + 1,
+			Player: member.Member.(string),
+			Name:   names[i].Val(),
+			Score:  member.Score,
+		})
+	}
+	return page, nil
+}
+
+// Leaderboard returns a page of a leaderboard.
+// Example: GET /leaderboard?board=wins&window=weekly&page=1&size=20
+func (g *game) Leaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+
+	page, err := queryInt(query.Get("page"), 1)
+	if err != nil {
+		http.Error(w, "page must be a number", http.StatusBadRequest)
+		return
+	}
+	size, err := queryInt(query.Get("size"), defaultLeaderboardSize)
+	if err != nil {
+		http.Error(w, "size must be a number", http.StatusBadRequest)
+		return
+	}
+
+	q := &leaderboardQuery{
+		Board:  query.Get("board"),
+		Window: query.Get("window"),
+		Period: query.Get("period"),
+		Page:   page,
+		Size:   size,
+	}
+	err = q.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	leaderboard, err := getLeaderboard(g.redisClient, q)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, leaderboard)
+}
+
+// SendLeaderboard sends a page of a leaderboard to the client
+func (p *player) SendLeaderboard(q *leaderboardQuery) error {
+	leaderboard, err := getLeaderboard(p.redisClient, q)
+	if err != nil {
+		return err
+	}
+	return p.WriteJSON(&message{Type: messageLeaderboard, Payload: leaderboard})
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+const (
+	// channel on which lobby chat messages and deletions are published to every player
+	lobbyChatChannel = "channel:lobby:chat"
+	// ids of the most recent lobby messages, oldest first, and the messages keyed by id
+	lobbyChatList = "list:lobby:chat"
+	lobbyChatHash = "hash:lobby:chat"
+	lobbyChatIDs  = "lobby:chat:id"
+	// players who may mute players and delete messages in the lobby
+	moderatorsSet = "set:moderators"
+
+	// messages sent to players when they join
+	lobbyChatHistory = 50
+
+	defaultMuteMinutes = 10
+	maxMuteMinutes     = 7 * 24 * 60
+)
+
+// lobbyMessage is a chat message sent to everyone in the lobby
+type lobbyMessage struct {
+	ID int64
+	*chatMessage
+}
+
+// lobbyMute is sent by a moderator to keep a player out of the lobby chat for a while
+type lobbyMute struct {
+	Player  string
+	Minutes int
+}
+
+func getLobbyChatRateKey(playerID string) string {
+	return "ratelimit:lobby:" + playerID
+}
+
+func getLobbyMuteKey(playerID string) string {
+	return "lobby:muted:" + playerID
+}
+
+// postLobbyMessageScript saves a lobby message, drops the oldest messages beyond the history kept and
+// publishes the message
+//
+// KEYS: lobby chat list, lobby chat hash
+// ARGV: message id, message, messages kept, channel, published message
+var postLobbyMessageScript = redis.NewScript(`
+redis.call("RPUSH", KEYS[1], ARGV[1])
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+while redis.call("LLEN", KEYS[1]) > tonumber(ARGV[3]) do
+	redis.call("HDEL", KEYS[2], redis.call("LPOP", KEYS[1]))
+end
+redis.call("PUBLISH", ARGV[4], ARGV[5])
+return 1
+`)
+
+// deleteLobbyMessageScript removes a lobby message and publishes its deletion. It returns 0 if there is
+// no such message
+//
+// KEYS: lobby chat list, lobby chat hash
+// ARGV: message id, channel, published message
+var deleteLobbyMessageScript = redis.NewScript(`
+if redis.call("HDEL", KEYS[2], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("LREM", KEYS[1], 0, ARGV[1])
+redis.call("PUBLISH", ARGV[2], ARGV[3])
+return 1
+`)
+
+// getLobbyChat returns the most recent lobby messages, oldest first
+func getLobbyChat(redisClient *redis.Client) ([]*lobbyMessage, error) {
+	ids, err := redisClient.LRange(lobbyChatList, 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get lobby chat")
+	}
+	messages := make([]*lobbyMessage, 0, len(ids))
+	if len(ids) == 0 {
+		return messages, nil
+	}
+	entries, err := redisClient.HMGet(lobbyChatHash, ids...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get lobby chat messages")
+	}
+	for _, entry := range entries {
+		// a message deleted since the ids were read
+		s, ok := entry.(string)
+		if !ok {
+			continue
+		}
+		msg := &lobbyMessage{}
+		err = json.Unmarshal([]byte(s), msg)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode lobby message")
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func isModerator(redisClient *redis.Client, playerID string) (bool, error) {
+	ok, err := redisClient.SIsMember(moderatorsSet, playerID).Result()
+	return ok, errors.Wrap(err, "failed to check for moderator")
+}
+
+// LobbyChat sends a message to every player in the lobby
+func (p *player) LobbyChat(text string) error {
+	text, err := validateChat(text)
+	if err != nil {
+		return err
+	}
+	muted, err := p.redisClient.Exists(getLobbyMuteKey(p.info.ID)).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to check for mute")
+	}
+	if muted == 1 {
+		return errors.New("you have been muted in the lobby")
+	}
+	ok, err := allowMessage(p.redisClient, getLobbyChatRateKey(p.info.ID), chatRateLimit, chatRateWindow)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("you are sending messages too quickly")
+	}
+
+	id, err := p.redisClient.Incr(lobbyChatIDs).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to number lobby message")
+	}
+	bs, err := json.Marshal(&lobbyMessage{
+		ID: id,
+		chatMessage: &chatMessage{
+			From: p.info.ID,
+			Name: p.info.Name,
+			Text: filterProfanity(text),
+			Time: millis(time.Now()),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode lobby message")
+	}
+
+	return errors.Wrap(
+		postLobbyMessageScript.Run(
+			p.redisClient, []string{lobbyChatList, lobbyChatHash},
+			id, string(bs), lobbyChatHistory, lobbyChatChannel, playerLobbyChat(string(bs)),
+		).Err(),
+		"failed to post lobby message",
+	)
+}
+
+// SendLobbyChat sends the client the most recent lobby messages
+func (p *player) SendLobbyChat() error {
+	messages, err := getLobbyChat(p.redisClient)
+	if err != nil {
+		return err
+	}
+	return p.WriteJSON(&message{Type: messageLobbyHistory, Payload: messages})
+}
+
+// DeleteLobbyMessage removes a message from the lobby chat of every player. Only moderators may delete messages
+func (p *player) DeleteLobbyMessage(id int64) error {
+	ok, err := isModerator(p.redisClient, p.info.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("only moderators may delete messages")
+	}
+	i, err := deleteLobbyMessageScript.Run(
+		p.redisClient, []string{lobbyChatList, lobbyChatHash},
+		id, lobbyChatChannel, playerLobbyDelete(id),
+	).Int64()
+	if err != nil {
+		return errors.Wrap(err, "failed to delete lobby message")
+	}
+	if i == 0 {
+		return errors.Errorf("lobby message %d not found", id)
+	}
+	return nil
+}
+
+// MutePlayer stops a player from chatting in the lobby for some minutes. Only moderators may mute players
+func (p *player) MutePlayer(mute *lobbyMute) error {
+	ok, err := isModerator(p.redisClient, p.info.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("only moderators may mute players")
+	}
+	if mute.Player == "" {
+		return errors.New("missing player to mute")
+	}
+	if mute.Minutes == 0 {
+		mute.Minutes = defaultMuteMinutes
+	}
+	if mute.Minutes < 0 || mute.Minutes > maxMuteMinutes {
+		return errors.Errorf("players may be muted for up to %d minutes", maxMuteMinutes)
+	}
+	err = p.redisClient.Set(
+		getLobbyMuteKey(mute.Player), p.info.ID, time.Duration(mute.Minutes)*time.Minute,
+	).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to mute player")
+	}
+	return p.WriteJSON(&message{
+		Type:    messageLobbyMute,
+		Payload: mute,
+	})
+}
+
+// parseLobbyMessageID reads the id of a lobby message from a client payload, which may be a number or a string
+func parseLobbyMessageID(payload interface{}) (int64, error) {
+	switch id := payload.(type) {
+	case float64:
+		return int64(id), nil
+	case string:
+		i, err := strconv.ParseInt(id, 10, 64)
+		return i, errors.Wrap(err, "failed to convert message id to int")
+	}
+	return 0, errors.Errorf("malformed message id %v", payload)
+}
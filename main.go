@@ -7,6 +7,8 @@ import (
 	"github.com/gidyon/micros/pkg/conn"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
@@ -24,6 +26,12 @@ func main() {
 		redisUser     = flag.String("redis-user", "root", "Redis host")
 		redisSchema   = flag.String("redis-schema", "game", "Redis schema")
 		redisPassword = flag.String("redis-password", "menevolent", "Redis password")
+		tokenSecret   = flag.String("token-secret", "", "Key for signing player identity tokens")
+		bots          = flag.String("bots", "easy,medium,perfect", "Comma separated difficulty levels of bots to run")
+		botSignupKey  = flag.String("bot-registration-key", "", "Key required to register external bots; registration is disabled without one")
+		organizerKey  = flag.String("organizer-key", "", "Key required to create and start tournaments; tournaments are disabled without one")
+		botDeadline   = flag.Duration("bot-move-deadline", 5*time.Second, "How long a bot has to make its move")
+		resumeGrace   = flag.Duration("resume-grace", 30*time.Second, "How long a game is held open after a player disconnects")
 		env           = flag.Bool("env", false, "Whether to read parameters from env variables")
 	)
 
@@ -38,6 +46,25 @@ func main() {
 		*redisUser = setIfEmpty(os.Getenv("REDIS_USER"), *redisUser)
 		*redisSchema = setIfEmpty(os.Getenv("REDIS_SCHEMA"), *redisSchema)
 		*redisPassword = setIfEmpty(os.Getenv("REDIS_PASSWORD"), *redisPassword)
+		*tokenSecret = setIfEmpty(os.Getenv("TOKEN_SECRET"), *tokenSecret)
+		*bots = setIfEmpty(os.Getenv("BOTS"), *bots)
+		*botSignupKey = setIfEmpty(os.Getenv("BOT_REGISTRATION_KEY"), *botSignupKey)
+		*organizerKey = setIfEmpty(os.Getenv("ORGANIZER_KEY"), *organizerKey)
+
+		if grace := os.Getenv("RESUME_GRACE"); grace != "" {
+			var err error
+			*resumeGrace, err = time.ParseDuration(grace)
+			if err != nil {
+				logrus.Fatalln(err)
+			}
+		}
+		if deadline := os.Getenv("BOT_MOVE_DEADLINE"); deadline != "" {
+			var err error
+			*botDeadline, err = time.ParseDuration(deadline)
+			if err != nil {
+				logrus.Fatalln(err)
+			}
+		}
 	}
 
 	// open redis connection
@@ -47,7 +74,14 @@ func main() {
 	})
 
 	// start game
-	g, err := newGame(redisClient)
+	g, err := newGame(redisClient, &gameOptions{
+		ResumeGrace:        *resumeGrace,
+		TokenSecret:        *tokenSecret,
+		Bots:               splitList(*bots),
+		BotMoveDeadline:    *botDeadline,
+		BotRegistrationKey: *botSignupKey,
+		OrganizerKey:       *organizerKey,
+	})
 	if err != nil {
 		logrus.Fatalln(err)
 	}
@@ -80,6 +114,17 @@ func main() {
 func handler(g *game, staticHandler http.Handler) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", g.PlayerJoin)
+	mux.HandleFunc("/ws/spectate", g.SpectatorJoin)
+	mux.HandleFunc("/ws/bot", g.BotJoin)
+	mux.HandleFunc("/bots", g.RegisterBot)
+	mux.HandleFunc("/games", g.ListGames)
+	mux.HandleFunc("/games/live", g.ListLiveGames)
+	mux.HandleFunc("/ratings", g.ListRatings)
+	mux.HandleFunc("/leaderboard", g.Leaderboard)
+	mux.HandleFunc("/games/", g.GetGame)
+	mux.HandleFunc("/series/", g.GetSeries)
+	mux.HandleFunc("/tournaments", g.Tournaments)
+	mux.HandleFunc("/tournaments/", g.Tournament)
 	mux.Handle("/", staticHandler)
 	return mux
 }
@@ -93,3 +138,15 @@ func setIfEmpty(strCurrent, strFinal string) string {
 	}
 	return strCurrent
 }
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// sorted set of players waiting for a game, scored by the time they joined the queue in milliseconds
+	matchmakingZSet = "zset:matchmaking"
+
+	// how often nodes try to pair queued players
+	matchmakingInterval = time.Second
+	// how often queued players are told their position
+	queuePositionInterval = 2 * time.Second
+	// a matched game nobody has moved in by then is closed, e.g. because the opponent's node went away
+	matchStartDeadline = 30 * time.Second
+	// how many of the longest waiting players are considered in one round of pairing
+	matchmakingBatch = 100
+
+	// players are paired with opponents whose rating is within a gap that widens the longer they wait
+	initialRatingGap = 100.0
+	ratingGapPerSec  = 10.0
+	maxRatingGap     = 800.0
+
+	// the same two players are paired at most this many times within the repeat window
+	maxRepeatPairings   = 3
+	repeatPairingWindow = time.Hour
+)
+
+// queuePosition is sent to queued players
+type queuePosition struct {
+	Position int64
+	Size     int64
+}
+
+// claimPairScript takes two players out of the queue only if both are still in it, so that no player is
+// matched twice even when several nodes pair at the same time.
+//
+// KEYS: matchmaking queue key
+// ARGV: first player id, second player id
+var claimPairScript = redis.NewScript(`
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) and redis.call("ZSCORE", KEYS[1], ARGV[2]) then
+	redis.call("ZREM", KEYS[1], ARGV[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+func claimPair(redisClient *redis.Client, first, second string) (bool, error) {
+	i, err := claimPairScript.Run(redisClient, []string{matchmakingZSet}, first, second).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim pair from queue")
+	}
+	return i == 1, nil
+}
+
+// matchmake pairs queued players until the node stops
+func (g *game) matchmake() {
+	ticker := time.NewTicker(matchmakingInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		logError(g.PairQueue())
+	}
+}
+
+// queuedPlayer is a player waiting in the matchmaking queue
+type queuedPlayer struct {
+	info   *playerInfo
+	waited time.Duration
+	paired bool
+}
+
+// ratingGap returns the largest rating difference the player accepts after waiting for the given time
+func ratingGap(waited time.Duration) float64 {
+	return math.Min(initialRatingGap+ratingGapPerSec*waited.Seconds(), maxRatingGap)
+}
+
+// getPairingsKey returns the counter of recent games the matchmaker started between two players
+func getPairingsKey(first, second string) string {
+	if first > second {
+		first, second = second, first
+	}
+	return "matchmaking:pairs:" + first + payloadSplit + second
+}
+
+// PairQueue pairs queued players with opponents of similar rating, longest waiting players first
+func (g *game) PairQueue() error {
+	members, err := g.redisClient.ZRangeWithScores(matchmakingZSet, 0, matchmakingBatch-1).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to get queued players")
+	}
+
+	now := time.Now()
+	queued := make([]*queuedPlayer, 0, len(members))
+	for _, member := range members {
+		playerID := member.Member.(string)
+		// a player that is no longer online left without leaving the queue, e.g. when its node went away
+		online, err := g.redisClient.SIsMember(playersSet, playerID).Result()
+		if err != nil {
+			return errors.Wrap(err, "failed to check for queued player")
+		}
+		if !online {
+			logrus.Infoln("dropped from queue: ", playerID)
+			err = g.redisClient.ZRem(matchmakingZSet, playerID).Err()
+			if err != nil {
+				return errors.Wrap(err, "failed to drop player from queue")
+			}
+			continue
+		}
+		info, err := getPlayerFromRedis(g.redisClient, playerID)
+		if err != nil {
+			return err
+		}
+		joinedAt := time.Unix(0, int64(member.Score)*int64(time.Millisecond))
+		queued = append(queued, &queuedPlayer{info: info, waited: now.Sub(joinedAt)})
+	}
+
+	for _, q := range queued {
+		if q.paired {
+			continue
+		}
+		opponent, err := g.findOpponent(q, queued)
+		if err != nil {
+			return err
+		}
+		if opponent == nil {
+			continue
+		}
+		ok, err := claimPair(g.redisClient, q.info.ID, opponent.info.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// another node got there first or a player left the queue
+			continue
+		}
+		q.paired, opponent.paired = true, true
+		err = g.StartMatch(q.info.ID, opponent.info.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findOpponent returns the unpaired player closest in rating to q that both accept and that q has not played
+// too often lately. It returns nil if there is none
+func (g *game) findOpponent(q *queuedPlayer, queued []*queuedPlayer) (*queuedPlayer, error) {
+	candidates := make([]*queuedPlayer, 0, len(queued))
+	for _, c := range queued {
+		if c == q || c.paired {
+			continue
+		}
+		gap := math.Abs(q.info.Rating - c.info.Rating)
+		if gap > ratingGap(q.waited) || gap > ratingGap(c.waited) {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return math.Abs(q.info.Rating-candidates[i].info.Rating) < math.Abs(q.info.Rating-candidates[j].info.Rating)
+	})
+
+	for _, c := range candidates {
+		pairings, err := g.redisClient.Get(getPairingsKey(q.info.ID, c.info.ID)).Int64()
+		if err != nil && err != redis.Nil {
+			return nil, errors.Wrap(err, "failed to get recent pairings")
+		}
+		if pairings < maxRepeatPairings {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// StartMatch tells two paired players to start a game; the player who waited longer plays X
+func (g *game) StartMatch(playerX, playerO string) error {
+	gameID, err := newGameID()
+	if err != nil {
+		return err
+	}
+	logrus.Infoln("matched players: ", playerX, playerO)
+
+	// count the pairing towards the repeat limit
+	key := getPairingsKey(playerX, playerO)
+	_, err = g.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Incr(key)
+		pipe.Expire(key, repeatPairingWindow)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to count pairing")
+	}
+
+	return publishMatch(g.redisClient, gameID, playerX, playerO)
+}
+
+// publishMatch tells both players to start the game with the given id
+func publishMatch(redisClient *redis.Client, gameID, playerX, playerO string) error {
+	err := redisClient.Publish(playerX, playerMatched(gameID, playerO, markX)).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to publish match")
+	}
+	return errors.Wrap(
+		redisClient.Publish(playerO, playerMatched(gameID, playerX, markO)).Err(),
+		"failed to publish match",
+	)
+}
+
+// JoinQueue puts the player in the queue for a game against an opponent of similar rating
+func (p *player) JoinQueue() error {
+	if p.info.State != playerStateFree {
+		return errors.New("you can only look for a game while free")
+	}
+	err := p.redisClient.ZAdd(matchmakingZSet, redis.Z{
+		Member: p.info.ID,
+		Score:  float64(time.Now().UnixNano() / int64(time.Millisecond)),
+	}).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to join matchmaking queue")
+	}
+	p.info.State = playerStateQueued
+
+	p.SendQueuePosition()
+
+	// try to pair right away instead of waiting for the next round
+	return p.game.PairQueue()
+}
+
+// LeaveQueue takes the player out of the queue. It fails if the player has already been matched
+func (p *player) LeaveQueue() error {
+	removed, err := p.redisClient.ZRem(matchmakingZSet, p.info.ID).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to leave matchmaking queue")
+	}
+	if removed == 0 {
+		return errors.New("you have already been matched")
+	}
+	p.info.State = playerStateFree
+	return nil
+}
+
+// SendQueuePosition tells the client its position in the queue, and does so again every queuePositionInterval
+// until the player is matched or leaves
+func (p *player) SendQueuePosition() {
+	if p.info.State != playerStateQueued {
+		return
+	}
+	rank, err := p.redisClient.ZRank(matchmakingZSet, p.info.ID).Result()
+	if err == redis.Nil {
+		// matched; the game is about to start
+		return
+	}
+	if err != nil {
+		logError(err)
+		return
+	}
+	size, err := p.redisClient.ZCard(matchmakingZSet).Result()
+	if err != nil {
+		logError(err)
+		return
+	}
+	err = p.WriteJSON(&message{
+		Type:    messageQueuePosition,
+		Payload: &queuePosition{Position: rank + 1, Size: size},
+	})
+	if err != nil {
+		return
+	}
+
+	var timer *time.Timer
+	timer = p.after(queuePositionInterval, func() {
+		// the player left the queue and joined it again in the meantime
+		if p.queueTimer == timer {
+			p.SendQueuePosition()
+		}
+	})
+	p.queueTimer = timer
+}
+
+// Matched starts the game the matchmaker paired the player into.
+// Example payload: 9f2c41d07ab3e5f6|player#0a1b2c3d4e5f6071|X
+func (p *player) Matched(payload string) error {
+	ss := strings.SplitN(payload, payloadSplit, 3)
+	if len(ss) != 3 {
+		return errors.Errorf("malformed match %q", payload)
+	}
+	gameID, opponentID, mark := ss[0], ss[1], ss[2]
+
+	// the opponent may have refused the match before we heard of it
+	refused, err := p.redisClient.HExists(getGameKey(gameID), "outcome").Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to get outcome of game")
+	}
+	if refused {
+		return p.MatchCancelled()
+	}
+
+	opponent, err := getPlayerFromRedis(p.redisClient, opponentID)
+	if err != nil {
+		return err
+	}
+	p.opponent = opponent
+	// a game started from our private room stays private
+	if p.room != "" {
+		p.room = ""
+		p.private = true
+	}
+	p.StartGame(gameID, mark)
+	p.after(matchStartDeadline, func() {
+		p.WriteError(p.CloseUnstartedMatch(gameID, opponentID))
+	})
+	return nil
+}
+
+// CloseUnstartedMatch abandons a matched game nobody has moved in by the start deadline, as if the player had
+// refused it, and takes the player back to the lobby
+func (p *player) CloseUnstartedMatch(gameID, opponentID string) error {
+	if p.info.State != playerStatePlaying || p.gameID != gameID {
+		return nil
+	}
+	moves, err := p.redisClient.LLen(getGameMovesKey(gameID)).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to get moves of game")
+	}
+	if moves > 0 {
+		return nil
+	}
+	err = p.RefuseGame(gameID, opponentID, p.mark)
+	if err != nil {
+		return err
+	}
+	return p.MatchRefused(gameID)
+}
+
+// matchable reports whether the player can start a game it was paired into: it is waiting in the lobby, in the
+// queue or in a room. Any other player is busy with a game of its own
+func (p *player) matchable() bool {
+	switch p.info.State {
+	case playerStateFree, playerStateQueued, playerStateHosting:
+		return true
+	case playerStateRequesting:
+		// players joining a room wait for the match
+		return p.private
+	}
+	return false
+}
+
+// RefuseMatch turns down a game the player was paired into while busy. The game is closed as abandoned before the
+// opponent is told, so an opponent that has not started it yet never will.
+// Example payload: 9f2c41d07ab3e5f6|player#0a1b2c3d4e5f6071|X
+func (p *player) RefuseMatch(payload string) error {
+	ss := strings.SplitN(payload, payloadSplit, 3)
+	if len(ss) != 3 {
+		return errors.Errorf("malformed match %q", payload)
+	}
+	return p.RefuseGame(ss[0], ss[1], ss[2])
+}
+
+// RefuseGame turns down a game the opponent has started while the player cannot play it; the player would have
+// played mark. Nobody wins a game that was never played
+func (p *player) RefuseGame(gameID, opponentID, mark string) error {
+	logInfo("player %s refused game %s while %s", p.info.ID, gameID, p.info.State)
+
+	playerX, playerO := p.info.ID, opponentID
+	if mark == markO {
+		playerX, playerO = opponentID, p.info.ID
+	}
+	// games from the queue are only saved by their players, so there may be no record yet
+	err := createGameRecord(p.redisClient, gameID, playerX, playerO, false, nil)
+	if err != nil {
+		return err
+	}
+	err = commitGameResult(p.redisClient, &gameResult{
+		GameID:  gameID,
+		PlayerX: playerX,
+		PlayerO: playerO,
+		Outcome: outcomeAbandoned,
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(
+		p.redisClient.Publish(opponentID, matchRefused(gameID)).Err(),
+		"failed to publish refused match",
+	)
+}
+
+// MatchRefused takes the player back to the lobby from a game its opponent refused to play. A match the player
+// has not started yet is cancelled when it arrives
+func (p *player) MatchRefused(gameID string) error {
+	if p.info.State != playerStatePlaying || p.gameID != gameID {
+		return nil
+	}
+	defer p.Reset()
+	p.WriteJSON(&message{
+		Type:    messagePlayerBusy,
+		Payload: "Opponent",
+	})
+	p.info.State = playerStateFree
+	return p.WriteErrors(p.JoinFreePlayers(), p.PublishPlayerJoined())
+}
+
+// MatchCancelled tells the player its opponent refused the match. Queued players go back in the queue
+func (p *player) MatchCancelled() error {
+	p.WriteJSON(&message{
+		Type:    messagePlayerBusy,
+		Payload: "Opponent",
+	})
+	queued := p.info.State == playerStateQueued
+	p.room = ""
+	p.Reset()
+	if queued {
+		return p.JoinQueue()
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
 	"strings"
 )
 
@@ -21,6 +24,8 @@ const (
 	playerStatePlaying    = "PLAYING"
 	playerStateRequesting = "REQUESTING"
 	playerStateGameOver   = "GAMEOVER"
+	playerStateQueued     = "QUEUED"
+	playerStateHosting    = "HOSTING"
 
 	// messages
 	messageWelcome           = "WELCOME"
@@ -41,7 +46,39 @@ const (
 	messageGameLost          = "LOST"
 	messageGameDraw          = "DRAW"
 	messageErrorHappened     = "ERROR"
+	messageSpectate          = "SPECTATE"
+	messageSession           = "SESSION"
+	messageIdentity          = "IDENTITY"
+	messagePlayerSetName     = "SETNAME"
+	messagePlayerNameChanged = "NAMECHANGED"
+	messageLeaderboard       = "LEADERBOARD"
+	messagePlayerFindGame    = "FINDGAME"
+	messagePlayerCancelFind  = "CANCELFIND"
+	messagePlayerMatched     = "MATCHED"
+	messageMatchRefused      = "MATCHREFUSED"
+	messageQueuePosition     = "QUEUEPOSITION"
+	messagePlayerResumed     = "RESUMED"
+	messageYourTurn          = "YOURTURN"
+	messageTournament        = "TOURNAMENT"
+	messageJoinTournament    = "JOINTOURNAMENT"
+	messageLeaveTournament   = "LEAVETOURNAMENT"
+	messageBerserk           = "BERSERK"
+	messageChat              = "CHAT"
+	messageLobbyChat         = "LOBBYCHAT"
+	messageLobbyHistory      = "LOBBYHISTORY"
+	messageLobbyDelete       = "LOBBYDELETE"
+	messageLobbyMute         = "LOBBYMUTE"
+	messageCreateRoom        = "CREATEROOM"
+	messageJoinRoom          = "JOINROOM"
+	messageCloseRoom         = "CLOSEROOM"
+	messageRoom              = "ROOM"
+	messageRoomExpired       = "ROOMEXPIRED"
+	messageRematch           = "REMATCH"
+	messageAcceptRematch     = "ACCEPTREMATCH"
+	messageDeclineRematch    = "DECLINEREMATCH"
+	messageSeries            = "SERIES"
 	messageSplit             = ":::"
+	payloadSplit             = "|"
 )
 
 func playerJoin(playerID string) string {
@@ -52,20 +89,101 @@ func playerLeft(playerID string) string {
 	return fmt.Sprintf("%s%s%s", messagePlayerLeft, messageSplit, playerID)
 }
 
-func playerRequestGame(playerID string) string {
-	return fmt.Sprintf("%s%s%s", messagePlayerRequestGame, messageSplit, playerID)
+// the settings are json encoded
+// Example: REQUESTGAME:::player#9f2c41d07ab3e5f6|{"BestOf":3}
+func playerRequestGame(playerID, settings string) string {
+	return fmt.Sprintf("%s%s%s%s%s", messagePlayerRequestGame, messageSplit, playerID, payloadSplit, settings)
 }
 
 func playerRejectGame(playerID string) string {
 	return fmt.Sprintf("%s%s%s", messagePlayerRejectGame, messageSplit, playerID)
 }
 
-func playerStartGame(playerID string) string {
-	return fmt.Sprintf("%s%s%s", messagePlayerStartGame, messageSplit, playerID)
+// Example: STARTGAME:::9f2c41d07ab3e5f6|player#0a1b2c3d4e5f6071
+func playerStartGame(gameID, playerID string) string {
+	return fmt.Sprintf("%s%s%s%s%s", messagePlayerStartGame, messageSplit, gameID, payloadSplit, playerID)
 }
 
-func playerMove(moveID string) string {
-	return fmt.Sprintf("%s%s%s", messagePlayerMove, messageSplit, moveID)
+// returns the game id and the player who accepted the challenge of a STARTGAME broadcast
+func fromStartGame(payload string) (string, string) {
+	ss := strings.SplitN(payload, payloadSplit, 2)
+	if len(ss) < 2 {
+		return payload, ""
+	}
+	return ss[0], ss[1]
+}
+
+// moves are numbered so that a board that already has a move can skip it
+// Example: PLAYERMOVE:::5|box-22
+func playerMove(number int, moveID string) string {
+	return fmt.Sprintf("%s%s%d%s%s", messagePlayerMove, messageSplit, number, payloadSplit, moveID)
+}
+
+// returns the number and id of a broadcast move
+func fromMoveBroadCast(payload string) (int, string) {
+	ss := strings.SplitN(payload, payloadSplit, 2)
+	if len(ss) < 2 {
+		return 0, payload
+	}
+	number, err := strconv.Atoi(ss[0])
+	if err != nil {
+		return 0, payload
+	}
+	return number, ss[1]
+}
+
+func playerNameChanged(playerID string) string {
+	return fmt.Sprintf("%s%s%s", messagePlayerNameChanged, messageSplit, playerID)
+}
+
+// Example: MATCHED:::9f2c41d07ab3e5f6|player#0a1b2c3d4e5f6071|X
+func playerMatched(gameID, opponentID, mark string) string {
+	return fmt.Sprintf(
+		"%s%s%s%s%s%s%s", messagePlayerMatched, messageSplit, gameID, payloadSplit, opponentID, payloadSplit, mark,
+	)
+}
+
+// Example: MATCHREFUSED:::9f2c41d07ab3e5f6
+func matchRefused(gameID string) string {
+	return fmt.Sprintf("%s%s%s", messageMatchRefused, messageSplit, gameID)
+}
+
+func playerResumed(sessionToken string) string {
+	return fmt.Sprintf("%s%s%s", messagePlayerResumed, messageSplit, sessionToken)
+}
+
+func tournamentUpdated(tournamentID string) string {
+	return fmt.Sprintf("%s%s%s", messageTournament, messageSplit, tournamentID)
+}
+
+func playerBerserk(playerID string) string {
+	return fmt.Sprintf("%s%s%s", messageBerserk, messageSplit, playerID)
+}
+
+// the payload is a json encoded chat message
+func playerChat(chatMsg string) string {
+	return fmt.Sprintf("%s%s%s", messageChat, messageSplit, chatMsg)
+}
+
+// the payload is a json encoded lobby message
+func playerLobbyChat(lobbyMsg string) string {
+	return fmt.Sprintf("%s%s%s", messageLobbyChat, messageSplit, lobbyMsg)
+}
+
+func playerLobbyDelete(messageID int64) string {
+	return fmt.Sprintf("%s%s%d", messageLobbyDelete, messageSplit, messageID)
+}
+
+func playerRematch(playerID string) string {
+	return fmt.Sprintf("%s%s%s", messageRematch, messageSplit, playerID)
+}
+
+func playerAcceptRematch(playerID string) string {
+	return fmt.Sprintf("%s%s%s", messageAcceptRematch, messageSplit, playerID)
+}
+
+func playerDeclineRematch(playerID string) string {
+	return fmt.Sprintf("%s%s%s", messageDeclineRematch, messageSplit, playerID)
 }
 
 func playerWon(winnerID string) string {
@@ -82,7 +200,8 @@ func playerBusy(playerID string) string {
 
 // returns the broadcast type and payload
 func fromBroadCast(broadcastMsg string) (string, string) {
-	ss := strings.Split(broadcastMsg, messageSplit)
+	// payloads such as chat messages may contain the separator themselves
+	ss := strings.SplitN(broadcastMsg, messageSplit, 2)
 	if len(ss) == 0 {
 		return "UNKNOWN", ""
 	}
@@ -91,3 +210,12 @@ func fromBroadCast(broadcastMsg string) (string, string) {
 	}
 	return ss[0], ss[1]
 }
+
+// decodePayload decodes a structured message payload into v
+func decodePayload(payload, v interface{}) error {
+	bs, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode payload")
+	}
+	return errors.Wrap(json.Unmarshal(bs, v), "failed to decode payload")
+}
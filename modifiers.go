@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// rule modifiers of classic games
+	modifierNone = ""
+	// completing a line loses
+	modifierMisere = "misere"
+	// players place either symbol on their turn; whoever completes a line of either wins
+	modifierWild = "wild"
+	// X places the odd numbers and O the even ones, each once; whoever completes a line summing to 15 wins
+	modifierNumerical = "numerical"
+
+	// moves that place a chosen symbol are stored as <move id>/<symbol> e.g box-22/O or box-13/7
+	symbolSeparator = "/"
+	// numbers placed in numerical tic-tac-toe and the sum of a winning line
+	maxNumber        = 9
+	numericalLineSum = 15
+
+	moveErrorSymbol = "BAD_SYMBOL"
+)
+
+var modifiers = []string{modifierMisere, modifierWild, modifierNumerical}
+
+// symbolMove is the payload of a PLAYERMOVE in wild and numerical tic-tac-toe.
+// Example payload: PLAYERMOVE {"Move": "box-22", "Symbol": "O"}
+type symbolMove struct {
+	Move   string
+	Symbol string
+}
+
+// validateModifier checks the modifier of a classic game. Wild and numerical tic-tac-toe are only played on a 3x3
+// board; bots search every symbol for every empty cell, which bigger boards would make too slow
+func validateModifier(s *gameSettings) error {
+	if s.Modifier == modifierNone {
+		return nil
+	}
+	if !contains(modifiers, s.Modifier) {
+		return errors.Errorf("unknown modifier %q, expected one of %v", s.Modifier, modifiers)
+	}
+	if hasSymbols(s.Modifier) && (s.Size != defaultBoardSize || s.WinLength != defaultBoardSize) {
+		return errors.Errorf("%s tic-tac-toe is played on a 3x3 board", s.Modifier)
+	}
+	return nil
+}
+
+// rejectModifier returns an error if a game other than classic tic-tac-toe is challenged with a modifier
+func rejectModifier(s *gameSettings) error {
+	if s.Modifier != modifierNone {
+		return errors.Errorf("modifiers are only available in %s games", variantClassic)
+	}
+	return nil
+}
+
+// hasSymbols reports whether players choose the symbol they place under the modifier
+func hasSymbols(modifier string) bool {
+	return modifier == modifierWild || modifier == modifierNumerical
+}
+
+// splitSymbol splits a move id into the id of the cell and the symbol placed, if any
+func splitSymbol(moveID string) (string, string) {
+	i := strings.LastIndex(moveID, symbolSeparator)
+	if i < 0 {
+		return moveID, ""
+	}
+	return moveID[:i], moveID[i+1:]
+}
+
+// symbols returns the symbols mark may place under the modifier in the order they are tried
+func (b *board) symbols(mark string) []string {
+	switch b.modifier {
+	case modifierWild:
+		return []string{markX, markO}
+	case modifierNumerical:
+		used := make(map[string]bool)
+		for _, row := range b.cells {
+			for _, cell := range row {
+				used[cell] = true
+			}
+		}
+		// X starts with 1 and O with 2
+		n := 1
+		if mark == markO {
+			n = 2
+		}
+		symbols := make([]string, 0)
+		for ; n <= maxNumber; n += 2 {
+			if !used[strconv.Itoa(n)] {
+				symbols = append(symbols, strconv.Itoa(n))
+			}
+		}
+		return symbols
+	default:
+		return []string{mark}
+	}
+}
+
+// checkSymbol validates the symbol of a move by mark and returns the symbol to place
+func (b *board) checkSymbol(mark, moveID, symbol string) (string, error) {
+	if !hasSymbols(b.modifier) {
+		if symbol != "" {
+			return "", newMoveError(moveErrorMalformed, moveID, "expected move of the form box-<row><col>")
+		}
+		return mark, nil
+	}
+	if symbol == "" {
+		return "", newMoveError(moveErrorMalformed, moveID, fmt.Sprintf(
+			`expected move of the form {"Move": "box-<row><col>", "Symbol": %q}`, b.symbols(mark)[0],
+		))
+	}
+	if !contains(b.symbols(mark), symbol) {
+		return "", newMoveError(moveErrorSymbol, moveID, fmt.Sprintf("symbol must be one of %v", b.symbols(mark)))
+	}
+	return symbol, nil
+}
+
+// wins reports whether the move by mark that placed a symbol at pos wins and who the winner is
+func (b *board) wins(mark string, pos position) (string, bool) {
+	switch b.modifier {
+	case modifierMisere:
+		return opponentMark(mark), b.completesLine(mark, pos)
+	case modifierWild:
+		return mark, b.completesLine(b.cells[pos.row][pos.col], pos)
+	case modifierNumerical:
+		return mark, b.completesSum(pos)
+	default:
+		return mark, b.completesLine(mark, pos)
+	}
+}
+
+// completesSum reports whether a full line through pos adds up to 15. Numerical boards are 3x3, so lines run
+// from edge to edge
+func (b *board) completesSum(pos position) bool {
+	for _, dir := range lineDirections {
+		// step back to the edge the line starts from
+		row, col := pos.row, pos.col
+		for b.inside(row-dir.row, col-dir.col) {
+			row, col = row-dir.row, col-dir.col
+		}
+		sum, n := 0, 0
+		for ; b.inside(row, col); row, col = row+dir.row, col+dir.col {
+			v, err := strconv.Atoi(b.cells[row][col])
+			if err != nil {
+				break
+			}
+			sum += v
+			n++
+		}
+		if n == b.size && sum == numericalLineSum {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestModifierResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		modifier string
+		moves    []string
+		winner   string
+		over     bool
+	}{
+		{
+			name:     "misere line loses",
+			modifier: modifierMisere,
+			moves:    []string{"box-11", "box-21", "box-12", "box-22", "box-13"},
+			winner:   markO,
+			over:     true,
+		},
+		{
+			name:     "misere line of the second player loses",
+			modifier: modifierMisere,
+			moves:    []string{"box-11", "box-21", "box-12", "box-22", "box-33", "box-23"},
+			winner:   markX,
+			over:     true,
+		},
+		{
+			name:     "misere full board",
+			modifier: modifierMisere,
+			moves: []string{
+				"box-11", "box-12", "box-13", "box-22", "box-21", "box-23", "box-32", "box-31", "box-33",
+			},
+			winner: markNone,
+			over:   true,
+		},
+		{
+			name:     "wild line of own symbol",
+			modifier: modifierWild,
+			moves:    []string{"box-11/X", "box-33/O", "box-12/X", "box-32/O", "box-13/X"},
+			winner:   markX,
+			over:     true,
+		},
+		{
+			name:     "wild line of the opponent's symbol",
+			modifier: modifierWild,
+			moves:    []string{"box-11/O", "box-33/X", "box-12/O", "box-31/X", "box-13/O"},
+			winner:   markX,
+			over:     true,
+		},
+		{
+			name:     "wild completed by the second player",
+			modifier: modifierWild,
+			moves:    []string{"box-11/X", "box-12/X", "box-33/O", "box-13/X"},
+			winner:   markO,
+			over:     true,
+		},
+		{
+			name:     "wild mixed line",
+			modifier: modifierWild,
+			moves:    []string{"box-11/X", "box-12/O", "box-13/X"},
+			winner:   markNone,
+			over:     false,
+		},
+		{
+			name:     "numerical row of 15",
+			modifier: modifierNumerical,
+			moves:    []string{"box-11/1", "box-31/2", "box-12/5", "box-33/4", "box-13/9"},
+			winner:   markX,
+			over:     true,
+		},
+		{
+			name:     "numerical diagonal completed by the second player",
+			modifier: modifierNumerical,
+			moves:    []string{"box-11/1", "box-22/6", "box-12/3", "box-33/8"},
+			winner:   markO,
+			over:     true,
+		},
+		{
+			name:     "numerical full line not adding up to 15",
+			modifier: modifierNumerical,
+			moves:    []string{"box-11/1", "box-12/2", "box-13/3"},
+			winner:   markNone,
+			over:     false,
+		},
+		{
+			name:     "numerical sum of 15 across two lines",
+			modifier: modifierNumerical,
+			moves:    []string{"box-11/7", "box-12/8", "box-21/9"},
+			winner:   markNone,
+			over:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newClassicBoard()
+			b.modifier = tt.modifier
+			err := b.Replay(tt.moves)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			winner, over := b.Result()
+			if winner != tt.winner || over != tt.over {
+				t.Errorf("Result() = %q, %v, want %q, %v", winner, over, tt.winner, tt.over)
+			}
+		})
+	}
+}
+
+func TestModifierSymbols(t *testing.T) {
+	tests := []struct {
+		name     string
+		modifier string
+		moves    []string
+		mark     string
+		moveID   string
+		code     string
+	}{
+		{
+			name:     "wild either symbol",
+			modifier: modifierWild,
+			mark:     markX,
+			moveID:   "box-22/O",
+		},
+		{
+			name:     "wild without symbol",
+			modifier: modifierWild,
+			mark:     markX,
+			moveID:   "box-22",
+			code:     moveErrorMalformed,
+		},
+		{
+			name:     "wild unknown symbol",
+			modifier: modifierWild,
+			mark:     markX,
+			moveID:   "box-22/Z",
+			code:     moveErrorSymbol,
+		},
+		{
+			name:     "numerical odd number for X",
+			modifier: modifierNumerical,
+			mark:     markX,
+			moveID:   "box-22/5",
+		},
+		{
+			name:     "numerical even number for X",
+			modifier: modifierNumerical,
+			mark:     markX,
+			moveID:   "box-22/4",
+			code:     moveErrorSymbol,
+		},
+		{
+			name:     "numerical odd number for O",
+			modifier: modifierNumerical,
+			moves:    []string{"box-11/1"},
+			mark:     markO,
+			moveID:   "box-22/3",
+			code:     moveErrorSymbol,
+		},
+		{
+			name:     "numerical number used before",
+			modifier: modifierNumerical,
+			moves:    []string{"box-11/1", "box-12/2"},
+			mark:     markX,
+			moveID:   "box-22/1",
+			code:     moveErrorSymbol,
+		},
+		{
+			name:     "numerical out of range",
+			modifier: modifierNumerical,
+			mark:     markX,
+			moveID:   "box-22/11",
+			code:     moveErrorSymbol,
+		},
+		{
+			name:     "misere places the own mark",
+			modifier: modifierMisere,
+			mark:     markX,
+			moveID:   "box-22/O",
+			code:     moveErrorMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newClassicBoard()
+			b.modifier = tt.modifier
+			err := b.Replay(tt.moves)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			err = b.Play(tt.mark, tt.moveID)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("Play() error = %v", err)
+				}
+				return
+			}
+			moveErr, ok := err.(*moveError)
+			if !ok || moveErr.Code != tt.code {
+				t.Errorf("Play() error = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestValidateModifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings gameSettings
+		valid    bool
+	}{
+		{name: "none", settings: gameSettings{Size: 5, WinLength: 4}, valid: true},
+		{
+			name:     "misere on a big board",
+			settings: gameSettings{Size: 5, WinLength: 4, Modifier: modifierMisere},
+			valid:    true,
+		},
+		{name: "wild on 3x3", settings: gameSettings{Size: 3, WinLength: 3, Modifier: modifierWild}, valid: true},
+		{name: "wild on a big board", settings: gameSettings{Size: 5, WinLength: 4, Modifier: modifierWild}},
+		{name: "numerical on a big board", settings: gameSettings{Size: 4, WinLength: 3, Modifier: modifierNumerical}},
+		{name: "unknown", settings: gameSettings{Size: 3, WinLength: 3, Modifier: "gravity"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateModifier(&tt.settings)
+			if (err == nil) != tt.valid {
+				t.Errorf("validateModifier() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Pallinder/go-randomdata"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	// hash of lower cased names to the id of the player who owns them
+	playerNamesHash = "hash:names"
+
+	minNameLength = 3
+	maxNameLength = 20
+
+	// attempts at finding a free random name before giving up
+	nameAttempts = 10
+)
+
+// letters, digits, spaces, underscores and dashes; must start and end with a letter or digit
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9 _-]*[A-Za-z0-9])?$`)
+
+// words that may not appear anywhere in a name
+var reservedWords = []string{
+	"admin", "moderator", "system", "server", "support", "official", "staff",
+}
+
+// names that may not be used as they are
+var reservedNames = []string{
+	"bot", "mod", "root", "null", "nil", "undefined", "you", "opponent", "anonymous",
+}
+
+// validateName checks the length, characters and reserved words of a display name
+func validateName(name string) error {
+	if len(name) < minNameLength || len(name) > maxNameLength {
+		return errors.Errorf("name must be between %d and %d characters long", minNameLength, maxNameLength)
+	}
+	if !nameRegexp.MatchString(name) {
+		return errors.New("name may only contain letters, digits, spaces, underscores and dashes")
+	}
+	normalized := strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(name))
+	for _, word := range reservedWords {
+		if strings.Contains(normalized, word) {
+			return errors.Errorf("name may not contain %q", word)
+		}
+	}
+	for _, reserved := range reservedNames {
+		if normalized == reserved {
+			return errors.Errorf("name %q is reserved", name)
+		}
+	}
+	return nil
+}
+
+// claimNameScript gives a name to a player if no other player has it, releasing the player's old name.
+//
+// KEYS: names hash, player key
+// ARGV: lower cased name, name, player id
+var claimNameScript = redis.NewScript(`
+local owner = redis.call("HGET", KEYS[1], ARGV[1])
+if owner and owner ~= ARGV[3] then
+	return 0
+end
+local old = redis.call("HGET", KEYS[2], "name")
+if old and string.lower(old) ~= ARGV[1] and redis.call("HGET", KEYS[1], string.lower(old)) == ARGV[3] then
+	redis.call("HDEL", KEYS[1], string.lower(old))
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+redis.call("HSET", KEYS[2], "name", ARGV[2])
+return 1
+`)
+
+// claimName sets the name of the player unless it belongs to someone else. Names are compared case insensitively
+func claimName(redisClient *redis.Client, playerID, name string) (bool, error) {
+	i, err := claimNameScript.Run(
+		redisClient, []string{playerNamesHash, playerID}, strings.ToLower(name), name, playerID,
+	).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim name")
+	}
+	return i == 1, nil
+}
+
+// assignName gives the player its preferred name if it is valid and free, otherwise a random one
+func assignName(redisClient *redis.Client, playerID, preferred string) (string, error) {
+	if preferred != "" && validateName(preferred) == nil {
+		ok, err := claimName(redisClient, playerID, preferred)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return preferred, nil
+		}
+	}
+
+	for i := 0; i < nameAttempts; i++ {
+		name := randomdata.SillyName()
+		if i > 0 {
+			// add a number to make the name less likely to be taken
+			name = fmt.Sprintf("%s%d", name, randomdata.Number(10, 1000))
+		}
+		if validateName(name) != nil {
+			continue
+		}
+		ok, err := claimName(redisClient, playerID, name)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return name, nil
+		}
+	}
+
+	return "", errors.New("failed to find a free name")
+}
+
+// SetName changes the player's display name and tells everyone in the lobby
+func (p *player) SetName(name string) error {
+	name = strings.TrimSpace(name)
+	err := validateName(name)
+	if err != nil {
+		return err
+	}
+	ok, err := claimName(p.redisClient, p.info.ID, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("name %q is taken", name)
+	}
+	p.info.Name = name
+	return p.PublishNameChanged()
+}
+
+func (p *player) PublishNameChanged() error {
+	// Example payload: NAMECHANGED:::myid
+	return errors.Wrap(
+		p.PublishMessage(playersChannel, playerNameChanged(p.info.ID)),
+		"failed to publish name changed message",
+	)
+}
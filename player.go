@@ -2,21 +2,34 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/Sirupsen/logrus"
 	"github.com/go-redis/redis"
-	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"sync"
 	"time"
 )
 
 type playerInfo struct {
-	ID    string
-	Name  string
-	State string
-	Won   int
-	Draw  int
-	Lost  int
+	ID               string
+	Name             string
+	State            string
+	Won              int
+	Draw             int
+	Lost             int
+	Rating           float64
+	RatingDeviation  float64
+	RatingVolatility float64
+	Streak           int
+	Bot              bool
+}
+
+func (p *playerInfo) rating() *rating {
+	return &rating{
+		Rating:     p.Rating,
+		Deviation:  p.RatingDeviation,
+		Volatility: p.RatingVolatility,
+	}
 }
 
 type playerEventB struct {
@@ -28,7 +41,7 @@ type player struct {
 	ctx            context.Context
 	cancel         func()
 	mu             *sync.Mutex // guards conn
-	conn           *websocket.Conn
+	conn           playerConn
 	game           *game
 	playersChannel chan *playerEventB
 	waitingChan    chan struct{}
@@ -36,6 +49,28 @@ type player struct {
 	opponent       *playerInfo
 	opponentID     string
 	info           *playerInfo
+	board          gameBoard
+	mark           string
+	gameID         string
+	free           *redis.PubSub
+	own            *redis.PubSub
+	session        string
+	handedOff      chan struct{}
+	turnTimer      *time.Timer
+	berserk        bool
+	following      sync.Map // ids of tournaments whose standings are sent to the client
+	room           string   // invite code of the private room the player is waiting in
+	private        bool     // whether the current game was started from a private room
+	challenged     bool     // the player is answering the opponent's challenge rather than waiting for an answer
+	spawned        bool     // a bot started for a single challenge
+	settings       *gameSettings
+	seriesID       string // id of the best-of-N series the current game belongs to
+	rematchOffered bool   // we offered the opponent a rematch
+	rematchPending bool   // the opponent offered us a rematch
+	rematchTimer   *time.Timer
+	queueTimer     *time.Timer   // sends the next queue position
+	requests       chan *message // messages from the client, handled by ReadChannels
+	events         chan func()   // timers that went off, run by ReadChannels
 }
 
 func (p *player) JoinGame() error {
@@ -63,6 +98,18 @@ func (p *player) JoinGame() error {
 	return nil
 }
 
+// Run handles all read/write events for the player until it leaves
+func (p *player) Run() {
+	go p.ReadConn()
+	go p.ReadChannels()
+	<-p.ctx.Done()
+	// the game goes on with the connection that resumed the session
+	if p.HandedOff() {
+		return
+	}
+	logError(p.LeaveGame())
+}
+
 func (p *player) LeaveGame() error {
 	// remove from available players set
 	err := p.redisClient.SRem(playersSet, p.info.ID).Err()
@@ -77,8 +124,14 @@ func (p *player) LeaveGame() error {
 	}
 
 	logrus.Infoln("player state: ", p.info.State)
-	// Exit from game if you were playing
-	if p.info.State != playerStateFree {
+	switch p.info.State {
+	case playerStateFree:
+	case playerStateQueued:
+		logError(p.LeaveQueue())
+	case playerStateHosting:
+		logError(p.CloseRoom())
+	default:
+		// Exit from game if you were playing
 		p.ExitGameAndPublish()
 	}
 
@@ -88,7 +141,22 @@ func (p *player) LeaveGame() error {
 		return errors.Wrap(err, "failed to publish leave to free players channel")
 	}
 
-	return nil
+	return p.DeleteSession()
+}
+
+// post runs fn in ReadChannels, one at a time with the client's requests and pub/sub messages, so that only
+// one goroutine ever changes the player. Nothing is run once the player has left
+func (p *player) post(fn func()) {
+	select {
+	case p.events <- fn:
+	case <-p.ctx.Done():
+	}
+}
+
+// after posts fn once d has passed. A stopped timer may already have posted fn, so fn checks that it is still
+// wanted
+func (p *player) after(d time.Duration, fn func()) *time.Timer {
+	return time.AfterFunc(d, func() { p.post(fn) })
 }
 
 func (p *player) cancelled() bool {
@@ -129,9 +197,13 @@ func (p *player) PublishBusyMessage(channel string) error {
 }
 
 func (p *player) PublishRequestGame(channel string) error {
-	// Example message: REQUESTGAME:::myid
+	settings, err := json.Marshal(p.settings)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode game settings")
+	}
+	// Example message: REQUESTGAME:::myid|{"BestOf":3}
 	return errors.Wrap(
-		p.PublishMessage(channel, playerRequestGame(p.info.ID)),
+		p.PublishMessage(channel, playerRequestGame(p.info.ID, string(settings))),
 		"failed to publish request game message",
 	)
 }
@@ -144,42 +216,46 @@ func (p *player) PublishRejectGame(channel string) error {
 	)
 }
 
-func (p *player) PublishStartGame(channel string) error {
-	// Example payload: STARTGAME:::myid
+func (p *player) PublishStartGame(gameID string) error {
+	// Example payload: STARTGAME:::gameid|myid
 	return errors.Wrap(
-		p.PublishMessageToGameChannel(playerStartGame(p.info.ID)),
+		p.PublishMessageToGameChannel(playerStartGame(gameID, p.info.ID)),
 		"failed to publish accept game message",
 	)
 }
 
 func (p *player) PublishMove(moveID string) error {
-	return errors.Wrap(
-		p.PublishMessageToGameChannel(playerMove(moveID)),
-		"failed to publish players move",
-	)
+	number := p.board.Moves() + 1
+	// record the move on the game and forward it to the opponent in one transaction
+	_, err := p.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.RPush(getGameMovesKey(p.gameID), moveID)
+		pipe.Publish(p.opponent.ID, playerMove(number, moveID))
+		pipe.Publish(getGameChannel(p.gameID), playerMove(number, moveID))
+		return nil
+	})
+	return errors.Wrap(err, "failed to publish players move")
 }
 
 func (p *player) PublishGameWon(winnerID string) error {
 	return errors.Wrap(
-		p.PublishMessageToGameChannel(playerWon(winnerID)),
+		p.WriteErrors(
+			p.PublishMessageToGameChannel(playerWon(winnerID)),
+			p.PublishMessage(getGameChannel(p.gameID), playerWon(winnerID)),
+		),
 		"failed to publish game won message",
 	)
 }
 
 func (p *player) PublishGameDraw() error {
 	return errors.Wrap(
-		p.PublishMessageToGameChannel(messageGameDraw),
+		p.WriteErrors(
+			p.PublishMessageToGameChannel(messageGameDraw),
+			p.PublishMessage(getGameChannel(p.gameID), messageGameDraw),
+		),
 		"failed to publish game draw message",
 	)
 }
 
-func (p *player) PublishGameRestart() error {
-	return errors.Wrap(
-		p.PublishMessageToGameChannel(messagePlayerRestartGame),
-		"failed to publish restart game message",
-	)
-}
-
 func (p *player) PublishGameExit() error {
 	return errors.Wrap(
 		p.PublishMessageToGameChannel(messagePlayerExitGame),
@@ -188,6 +264,9 @@ func (p *player) PublishGameExit() error {
 }
 
 func (p *player) PublishPlayerJoined() error {
+	if p.spawned {
+		return nil
+	}
 	// Example payload: LEFT:::myid
 	return errors.Wrap(
 		p.PublishMessage(playersChannel, playerJoin(p.info.ID)),
@@ -212,6 +291,10 @@ func (p *player) ExitFreePlayers() error {
 }
 
 func (p *player) JoinFreePlayers() error {
+	// bots started for a challenge are never listed in the lobby
+	if p.spawned {
+		return nil
+	}
 	// add from available players set
 	return errors.Wrap(
 		p.redisClient.ZAdd(playersZSet, redis.Z{
@@ -225,7 +308,22 @@ func (p *player) JoinFreePlayers() error {
 func (p *player) Reset() {
 	p.CloseWaitingChan()
 	p.opponent = nil
+	p.board = nil
+	p.mark = markNone
+	p.gameID = ""
+	p.StopTurnClock()
+	p.berserk = false
+	p.private = false
+	p.challenged = false
+	p.settings = nil
+	p.seriesID = ""
+	p.ClearRematch()
 	p.info.State = playerStateFree
+	logError(p.SaveSessionGame())
+	// a bot started for a challenge is done once it is back in the lobby; it stops after the current message
+	if p.spawned {
+		go p.post(p.cancel)
+	}
 }
 
 func (p *player) TimeOperation(successFn, timedOutFn func()) {
@@ -242,7 +340,7 @@ func (p *player) TimeOperation(successFn, timedOutFn func()) {
 		}
 	case <-time.After(10 * time.Second):
 		if timedOutFn != nil {
-			timedOutFn()
+			p.post(timedOutFn)
 		}
 	}
 }
@@ -272,6 +370,10 @@ func (p *player) WriteJSON(msg *message) error {
 
 func (p *player) WriteError(err error) error {
 	if err != nil {
+		// rejected moves are sent as typed errors so the client can tell why
+		if moveErr, ok := errors.Cause(err).(*moveError); ok {
+			return p.WriteJSON(&message{Type: messageErrorHappened, Payload: moveErr})
+		}
 		return p.WriteJSON(&message{Type: messageErrorHappened, Payload: err.Error()})
 	}
 	return nil
@@ -290,7 +392,7 @@ func (p *player) WriteErrorString(errMsg string) error {
 	return p.WriteJSON(&message{Type: messageErrorHappened, Payload: errMsg})
 }
 
-func (p *player) StartGame() {
+func (p *player) StartGame(gameID, mark string) {
 	p.CloseWaitingChan()
 	err := p.WriteErrors(p.ExitFreePlayers(), p.PublishPlayerLeave())
 	if err != nil {
@@ -304,8 +406,23 @@ func (p *player) StartGame() {
 	if err != nil {
 		return
 	}
+	// the player who requested the game plays X and moves first
+	p.gameID = gameID
+	p.mark = mark
+	p.board = p.settings.newBoard()
+	// a series is known by the id of its first game
+	if p.settings != nil && p.settings.BestOf > 1 {
+		p.seriesID = gameID
+	}
+	err = p.WriteErrors(p.CreateGameRecord(), p.SaveSessionGame())
+	if err != nil {
+		p.WriteError(err)
+		return
+	}
 	// update your state to playing
 	p.info.State = playerStatePlaying
+	p.SendBoard()
+	p.WriteError(p.SendTurn())
 }
 
 func (p *player) RestartGame() {
@@ -317,8 +434,234 @@ func (p *player) RestartGame() {
 	if err != nil {
 		return
 	}
+	p.gameID = nextGameID(p.gameID)
+	// players take turns to move first
+	p.mark = opponentMark(p.mark)
+	p.board = p.settings.newBoard()
+	err = p.WriteErrors(p.CreateGameRecord(), p.SaveSessionGame())
+	if err != nil {
+		p.WriteError(err)
+		return
+	}
 	// update your state to playing
 	p.info.State = playerStatePlaying
+	p.SendBoard()
+	p.WriteError(p.SendTurn())
+}
+
+// SendBoard sends the client its mark, whose turn it is and the cells of the board
+func (p *player) SendBoard() error {
+	return p.WriteJSON(&message{
+		Type:    messageGameOn,
+		Payload: p.board.State(p.mark),
+	})
+}
+
+// PlayMove validates the client's move against the board and forwards it to the opponent
+func (p *player) PlayMove(moveID string) error {
+	err := p.board.Check(p.mark, moveID)
+	if err != nil {
+		return err
+	}
+	// publish the move on opponent channel
+	err = p.PublishMove(moveID)
+	if err != nil {
+		return err
+	}
+	p.StopTurnClock()
+	err = p.board.Play(p.mark, moveID)
+	if err != nil {
+		return err
+	}
+
+	winner, over := p.board.Result()
+	if !over {
+		return nil
+	}
+	// the player who made the final move commits the result for both players and announces it
+	err = p.CommitResult(winner)
+	if err != nil {
+		return err
+	}
+	if winner == markNone {
+		err = p.PublishGameDraw()
+	} else {
+		err = p.PublishGameWon(p.playerIDForMark(winner))
+	}
+	if err != nil {
+		return err
+	}
+	return p.GameOver(winner)
+}
+
+// CreateGameRecord saves the current game; whichever player gets there first creates it
+func (p *player) CreateGameRecord() error {
+	err := createGameRecord(
+		p.redisClient, p.gameID, p.playerIDForMark(markX), p.playerIDForMark(markO), p.private, p.settings,
+	)
+	if err != nil || p.seriesID == "" {
+		return err
+	}
+	return errors.Wrap(
+		p.redisClient.HSet(getGameKey(p.gameID), "series", p.seriesID).Err(),
+		"failed to save series of game",
+	)
+}
+
+// CommitResult saves the outcome of the current game together with both players' stats and ratings
+func (p *player) CommitResult(winner string) error {
+	result := &gameResult{
+		GameID:  p.gameID,
+		PlayerX: p.playerIDForMark(markX),
+		PlayerO: p.playerIDForMark(markO),
+		Outcome: outcomeDraw,
+	}
+	scoreX := scoreDraw
+	if winner != markNone {
+		result.Outcome = winner
+		result.Winner = p.playerIDForMark(winner)
+		scoreX = scoreLoss
+		if winner == markX {
+			scoreX = scoreWin
+		}
+	}
+
+	err := p.commitRated(result, scoreX)
+	if err != nil || p.seriesID == "" {
+		return err
+	}
+	return recordSeriesGame(p.redisClient, p.seriesID, p.gameID, result.Winner)
+}
+
+// commitRated rates a game from the ratings saved in redis rather than our possibly stale copies and commits it.
+// Another game of either player may finish at the same time, in which case the game is rated again from the new
+// ratings
+func (p *player) commitRated(result *gameResult, scoreX float64) error {
+	// games against the server's bots are not rated, so that nobody can climb the leaderboards on an easy bot
+	if isBuiltinBot(result.PlayerX) || isBuiltinBot(result.PlayerO) {
+		return commitGameResult(p.redisClient, result)
+	}
+	var err error
+	for i := 0; i < commitResultAttempts; i++ {
+		var playerX, playerO *playerInfo
+		playerX, err = getPlayerFromRedis(p.redisClient, result.PlayerX)
+		if err != nil {
+			return err
+		}
+		playerO, err = getPlayerFromRedis(p.redisClient, result.PlayerO)
+		if err != nil {
+			return err
+		}
+		result.PriorX, result.PriorO = playerX.rating(), playerO.rating()
+		result.RatingX = updateRating(result.PriorX, result.PriorO, scoreX)
+		result.RatingO = updateRating(result.PriorO, result.PriorX, 1-scoreX)
+
+		err = commitGameResult(p.redisClient, result)
+		if err != errRatingChanged {
+			break
+		}
+	}
+	return err
+}
+
+// AbandonGame closes the record of a game that was left before it was over, along with its series. The game is
+// lost by the player who left and won by the player who stayed
+func (p *player) AbandonGame() error {
+	if p.gameID == "" || p.board == nil {
+		return nil
+	}
+	// a series that is left, even between games, goes to the player who stayed
+	if p.seriesID != "" {
+		err := abandonSeries(p.redisClient, p.seriesID, p.opponent.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if _, over := p.board.Result(); over {
+		return nil
+	}
+	scoreX := scoreWin
+	if p.mark == markX {
+		scoreX = scoreLoss
+	}
+	err := p.commitRated(&gameResult{
+		GameID:  p.gameID,
+		PlayerX: p.playerIDForMark(markX),
+		PlayerO: p.playerIDForMark(markO),
+		Outcome: outcomeAbandoned,
+		Winner:  p.opponent.ID,
+	}, scoreX)
+	if err != nil {
+		return err
+	}
+	// let spectators know the game has ended
+	return errors.Wrap(
+		p.PublishMessage(getGameChannel(p.gameID), playerExitGame(p.info.ID)),
+		"failed to publish exited game message to spectators",
+	)
+}
+
+// GameOver updates the player's local stats from the result of the game and notifies the client
+func (p *player) GameOver(winner string) error {
+	p.InitWaitingChan()
+	p.info.State = playerStateGameOver
+
+	// pick up the stats and rating which were committed with the result
+	p.ReloadStats()
+
+	p.ClearRematch()
+
+	var err error
+	if winner == markNone {
+		err = p.WriteJSON(&message{
+			Type:    messageGameDraw,
+			Payload: "Draw!",
+		})
+	}
+	if winner != markNone {
+		err = p.WriteJSON(&message{
+			Type:    messageGameWon,
+			Payload: p.playerIDForMark(winner),
+		})
+	}
+	if err != nil {
+		return err
+	}
+	// arena players are paired again from the lobby as soon as their game is over
+	arena, err := isArenaGame(p.redisClient, p.gameID)
+	if err != nil {
+		return err
+	}
+	if arena {
+		return p.ReturnToArena()
+	}
+	if p.seriesID == "" {
+		return nil
+	}
+	return p.SeriesGameOver()
+}
+
+// ReloadStats replaces the player's copy of its stats and rating with the ones saved in redis
+func (p *player) ReloadStats() {
+	saved, err := getPlayerFromRedis(p.redisClient, p.info.ID)
+	if err != nil {
+		logError(err)
+		return
+	}
+	p.info.Won = saved.Won
+	p.info.Lost = saved.Lost
+	p.info.Draw = saved.Draw
+	p.info.Rating = saved.Rating
+	p.info.RatingDeviation = saved.RatingDeviation
+	p.info.RatingVolatility = saved.RatingVolatility
+	p.info.Streak = saved.Streak
+}
+
+func (p *player) playerIDForMark(mark string) string {
+	if mark == p.mark {
+		return p.info.ID
+	}
+	return p.opponent.ID
 }
 
 func (p *player) ExitGameAndPublish() {
@@ -326,12 +669,13 @@ func (p *player) ExitGameAndPublish() {
 	logrus.Infoln("i have exited: ", p.info.Name)
 
 	p.info.State = playerStateFree
-	// join free players
-	// publish join
-	err := p.WriteErrors(p.PublishGameExit(), p.JoinFreePlayers(), p.PublishPlayerJoined())
+	// close the game record, join free players and publish join
+	err := p.WriteErrors(p.AbandonGame(), p.PublishGameExit(), p.JoinFreePlayers(), p.PublishPlayerJoined())
 	if err != nil {
 		return
 	}
+	// leaving a game loses it
+	p.ReloadStats()
 }
 
 func (p *player) ExitGame() {
@@ -339,6 +683,8 @@ func (p *player) ExitGame() {
 	logrus.Infoln("i have been exited: ", p.info.Name)
 
 	p.info.State = playerStateFree
+	// the opponent leaving the game loses it
+	p.ReloadStats()
 	// join free players
 	// publish join
 	err := p.WriteErrors(p.JoinFreePlayers(), p.PublishPlayerJoined())
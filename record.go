@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sorted set of games that are being played, scored by start time
+	liveGamesZSet = "zset:games:live"
+
+	// game record states
+	gameStatePlaying = "PLAYING"
+	gameStateOver    = "OVER"
+
+	// game outcomes
+	outcomeWonX      = markX
+	outcomeWonO      = markO
+	outcomeDraw      = "DRAW"
+	outcomeAbandoned = "ABANDONED"
+)
+
+// gameRecord is a single game between two players as stored in redis
+type gameRecord struct {
+	ID        string
+	PlayerX   string
+	PlayerO   string
+	State     string
+	StartedAt int64
+	EndedAt   int64
+	Moves     []string
+	Outcome   string
+	Winner    string
+	// id of the tournament the game was played in, if any
+	Tournament string
+	// whether the players went berserk in an arena game
+	BerserkX bool
+	BerserkO bool
+	// private games are played from an invite code and are not listed with the live games
+	Private bool
+	// id of the best-of-N series the game was played in, if any
+	Series string
+	// game played, one of the registered rules
+	Variant string
+	// number of rows and columns of the board and how many marks in a row won
+	Size      int
+	WinLength int
+	// rule modifier the game was played with, if any
+	Modifier string
+}
+
+func getGameKey(id string) string {
+	return "game:" + id
+}
+
+func getGameMovesKey(id string) string {
+	return "game:" + id + ":moves"
+}
+
+// getGameChannel returns the pub/sub channel on which a game's moves and result are published for spectators
+func getGameChannel(id string) string {
+	return "channel:game:" + id
+}
+
+// getPlayerGamesKey returns the sorted set of a player's game ids scored by start time
+func getPlayerGamesKey(playerID string) string {
+	return "zset:games:" + playerID
+}
+
+func newGameID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate game id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// nextGameID returns the id of the game played after a restart; both players derive it on their own.
+// Example: 9f2c41d07ab3e5f6 -> 9f2c41d07ab3e5f6-2 -> 9f2c41d07ab3e5f6-3
+func nextGameID(id string) string {
+	i := strings.LastIndex(id, "-")
+	if i < 0 {
+		return id + "-2"
+	}
+	round, err := strconv.Atoi(id[i+1:])
+	if err != nil {
+		return id + "-2"
+	}
+	return id[:i+1] + strconv.Itoa(round+1)
+}
+
+// createGameScript saves a new game unless it already exists, so both players may create it.
+// The game is also added to the history of both players and, unless it is private, to the live games.
+//
+// KEYS: game key, player X games key, player O games key, live games key
+// ARGV: id, player X id, player O id, start time, private (1 or 0), board size, win length, variant, modifier
+var createGameScript = redis.NewScript(`
+if redis.call("HSETNX", KEYS[1], "id", ARGV[1]) == 0 then
+	return 0
+end
+redis.call(
+	"HMSET", KEYS[1], "playerX", ARGV[2], "playerO", ARGV[3], "state", "PLAYING", "startedAt", ARGV[4],
+	"size", ARGV[6], "winLength", ARGV[7], "variant", ARGV[8]
+)
+if ARGV[9] ~= "" then
+	redis.call("HSET", KEYS[1], "modifier", ARGV[9])
+end
+redis.call("ZADD", KEYS[2], ARGV[4], ARGV[1])
+redis.call("ZADD", KEYS[3], ARGV[4], ARGV[1])
+if ARGV[5] == "1" then
+	redis.call("HSET", KEYS[1], "private", 1)
+else
+	redis.call("ZADD", KEYS[4], ARGV[4], ARGV[1])
+end
+return 1
+`)
+
+// createGameRecord saves a new game played with the given settings; nil settings are a classic game
+func createGameRecord(
+	redisClient *redis.Client, id, playerX, playerO string, private bool, settings *gameSettings,
+) error {
+	if settings == nil {
+		settings = defaultGameSettings()
+	}
+	return errors.Wrapf(
+		createGameScript.Run(
+			redisClient,
+			[]string{getGameKey(id), getPlayerGamesKey(playerX), getPlayerGamesKey(playerO), liveGamesZSet},
+			id, playerX, playerO, time.Now().Unix(), private, settings.Size, settings.WinLength, settings.Variant,
+			settings.Modifier,
+		).Err(),
+		"failed to create record of game %s", id,
+	)
+}
+
+// gameResult is the outcome of a game to be committed
+type gameResult struct {
+	GameID  string
+	PlayerX string
+	PlayerO string
+	Outcome string
+	Winner  string
+	// new ratings of the players; nil if the game is not rated e.g when it was abandoned
+	RatingX *rating
+	RatingO *rating
+	// ratings the new ones were worked out from; the result is refused if either has changed since
+	PriorX *rating
+	PriorO *rating
+}
+
+// errRatingChanged is returned when a player's rating changed between rating a game and committing its result
+var errRatingChanged = errors.New("rating changed while the game was rated")
+
+// commitResultScript records the outcome of a game and updates both players' stats and ratings in one step.
+// The outcome field is only ever set once, so running the script again for the same game does nothing.
+//
+// An abandoned game counts as won by its winner, if it has one, and lost by the other player. Rated games also
+// update the rating, wins and streak leaderboards of both players.
+//
+// KEYS: game key, player X key, player O key, live games key, player X ratings key, player O ratings key,
+// then the all-time, weekly and monthly leaderboards for rating, wins and streak in that order, then the current
+// weekly and monthly streaks
+// ARGV: outcome (X, O, DRAW or ABANDONED), winner id, end time, id,
+// then, for rated games, rating, deviation, volatility and rating history entry of X followed by those of O,
+// the expiry in seconds of weekly and monthly leaderboards and lastly the rating, deviation and volatility the
+// game was rated from for X followed by those of O.
+// Returns -1 without saving anything if the rating of either player is no longer the one the game was rated from
+var commitResultScript = redis.NewScript(`
+if redis.call("HEXISTS", KEYS[1], "outcome") == 1 then
+	return 0
+end
+if #ARGV > 4 then
+	for i = 0, 1 do
+		local saved = redis.call("HMGET", KEYS[2 + i], "rating", "deviation", "volatility")
+		for j = 1, 3 do
+			-- players who were never rated have the default rating
+			if saved[j] and tonumber(saved[j]) ~= tonumber(ARGV[14 + i * 3 + j]) then
+				return -1
+			end
+		end
+	end
+end
+redis.call("HSET", KEYS[1], "outcome", ARGV[1])
+redis.call("HMSET", KEYS[1], "state", "OVER", "winner", ARGV[2], "endedAt", ARGV[3])
+redis.call("ZREM", KEYS[4], ARGV[4])
+-- an abandoned game is won by the player who stayed, if anyone played it
+local xWon = ARGV[1] == "X" or (ARGV[1] == "ABANDONED" and ARGV[2] == KEYS[2])
+local oWon = ARGV[1] == "O" or (ARGV[1] == "ABANDONED" and ARGV[2] == KEYS[3])
+if ARGV[1] == "DRAW" then
+	redis.call("HINCRBY", KEYS[2], "draw", 1)
+	redis.call("HINCRBY", KEYS[3], "draw", 1)
+elseif xWon then
+	redis.call("HINCRBY", KEYS[2], "won", 1)
+	redis.call("HINCRBY", KEYS[3], "lost", 1)
+elseif oWon then
+	redis.call("HINCRBY", KEYS[2], "lost", 1)
+	redis.call("HINCRBY", KEYS[3], "won", 1)
+end
+if #ARGV > 4 then
+	redis.call("HMSET", KEYS[2], "rating", ARGV[5], "deviation", ARGV[6], "volatility", ARGV[7])
+	redis.call("RPUSH", KEYS[5], ARGV[8])
+	redis.call("HMSET", KEYS[3], "rating", ARGV[9], "deviation", ARGV[10], "volatility", ARGV[11])
+	redis.call("RPUSH", KEYS[6], ARGV[12])
+
+	local function rank(player, rating, won)
+		for i = 7, 9 do
+			redis.call("ZADD", KEYS[i], rating, player)
+		end
+		if not won then
+			redis.call("HSET", player, "streak", 0)
+			redis.call("HDEL", KEYS[16], player)
+			redis.call("HDEL", KEYS[17], player)
+			return
+		end
+		-- each window counts the streak from its own start
+		local streaks = {
+			redis.call("HINCRBY", player, "streak", 1),
+			redis.call("HINCRBY", KEYS[16], player, 1),
+			redis.call("HINCRBY", KEYS[17], player, 1),
+		}
+		for i = 10, 12 do
+			redis.call("ZINCRBY", KEYS[i], 1, player)
+		end
+		for i = 13, 15 do
+			local best = tonumber(redis.call("ZSCORE", KEYS[i], player) or 0)
+			if streaks[i - 12] > best then
+				redis.call("ZADD", KEYS[i], streaks[i - 12], player)
+			end
+		end
+	end
+	rank(KEYS[2], ARGV[5], xWon)
+	rank(KEYS[3], ARGV[9], oWon)
+
+	for _, i in ipairs({8, 11, 14, 16}) do
+		redis.call("EXPIRE", KEYS[i], ARGV[13])
+	end
+	for _, i in ipairs({9, 12, 15, 17}) do
+		redis.call("EXPIRE", KEYS[i], ARGV[14])
+	end
+end
+return 1
+`)
+
+const commitResultAttempts = 3
+
+// commitGameResult atomically saves the outcome of a game and updates the stats and ratings of both players.
+// It is safe to call more than once for the same game. Rated results fail with errRatingChanged if either
+// player's rating changed since it was read; they have to be rated again.
+func commitGameResult(redisClient *redis.Client, result *gameResult) error {
+	now := time.Now()
+	endedAt := now.Unix()
+	args := []interface{}{result.Outcome, result.Winner, endedAt, result.GameID}
+	if result.RatingX != nil && result.RatingO != nil {
+		for _, r := range []*rating{result.RatingX, result.RatingO} {
+			change, err := json.Marshal(&ratingChange{
+				GameID:     result.GameID,
+				Rating:     r.Rating,
+				Deviation:  r.Deviation,
+				Volatility: r.Volatility,
+				Time:       endedAt,
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to encode rating change")
+			}
+			args = append(args, r.Rating, r.Deviation, r.Volatility, string(change))
+		}
+		args = append(args, int64(weeklyLeaderboardTTL/time.Second), int64(monthlyLeaderboardTTL/time.Second))
+		for _, r := range []*rating{result.PriorX, result.PriorO} {
+			args = append(args, r.Rating, r.Deviation, r.Volatility)
+		}
+	}
+
+	keys := []string{
+		getGameKey(result.GameID),
+		result.PlayerX,
+		result.PlayerO,
+		liveGamesZSet,
+		getPlayerRatingsKey(result.PlayerX),
+		getPlayerRatingsKey(result.PlayerO),
+	}
+	for _, board := range leaderboards {
+		keys = append(keys, getLeaderboardKeys(board, now)...)
+	}
+	for _, window := range []string{windowWeekly, windowMonthly} {
+		keys = append(keys, getStreaksKey(window, leaderboardPeriod(window, now)))
+	}
+
+	var err error
+	for i := 0; i < commitResultAttempts; i++ {
+		var n int64
+		n, err = commitResultScript.Run(redisClient, keys, args...).Int64()
+		if err == nil && n == -1 {
+			return errRatingChanged
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.Wrapf(err, "failed to commit result of game %s", result.GameID)
+}
+
+func getGameFromRedis(redisClient *redis.Client, id string) (*gameRecord, error) {
+	gameMap, err := redisClient.HGetAll(getGameKey(id)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get game from map")
+	}
+	if gameMap["id"] == "" {
+		return nil, errors.Errorf("game %s not found", id)
+	}
+	moves, err := redisClient.LRange(getGameMovesKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get game moves")
+	}
+	g := &gameRecord{
+		ID:         gameMap["id"],
+		PlayerX:    gameMap["playerX"],
+		PlayerO:    gameMap["playerO"],
+		State:      gameMap["state"],
+		Moves:      moves,
+		Outcome:    gameMap["outcome"],
+		Winner:     gameMap["winner"],
+		Tournament: gameMap["tournament"],
+		BerserkX:   gameMap["berserk"+markX] == "1",
+		BerserkO:   gameMap["berserk"+markO] == "1",
+		Private:    gameMap["private"] == "1",
+		Series:     gameMap["series"],
+		Variant:    setIfEmpty(gameMap["variant"], variantClassic),
+		Size:       defaultBoardSize,
+		WinLength:  defaultBoardSize,
+		Modifier:   gameMap["modifier"],
+	}
+	// games saved before boards could be resized are classic games
+	if gameMap["size"] != "" {
+		g.Size, err = strconv.Atoi(gameMap["size"])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert size to int")
+		}
+	}
+	if gameMap["winLength"] != "" {
+		g.WinLength, err = strconv.Atoi(gameMap["winLength"])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert winLength to int")
+		}
+	}
+	if gameMap["startedAt"] != "" {
+		g.StartedAt, err = strconv.ParseInt(gameMap["startedAt"], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert startedAt to int")
+		}
+	}
+	if gameMap["endedAt"] != "" {
+		g.EndedAt, err = strconv.ParseInt(gameMap["endedAt"], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert endedAt to int")
+		}
+	}
+	return g, nil
+}
+
+// settings returns the settings the game was played with
+func (r *gameRecord) settings() *gameSettings {
+	return &gameSettings{BestOf: 1, Variant: r.Variant, Size: r.Size, WinLength: r.WinLength, Modifier: r.Modifier}
+}
+
+// getPlayerGames returns a page of the player's games, newest first
+func getPlayerGames(redisClient *redis.Client, playerID string, offset, limit int64) ([]*gameRecord, error) {
+	ids, err := redisClient.ZRevRange(getPlayerGamesKey(playerID), offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get player games")
+	}
+	games := make([]*gameRecord, 0, len(ids))
+	for _, id := range ids {
+		g, err := getGameFromRedis(redisClient, id)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, g)
+	}
+	return games, nil
+}
+
+// getLiveGames returns a page of the games being played, newest first
+func getLiveGames(redisClient *redis.Client, offset, limit int64) ([]*gameRecord, error) {
+	ids, err := redisClient.ZRevRange(liveGamesZSet, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get live games")
+	}
+	games := make([]*gameRecord, 0, len(ids))
+	for _, id := range ids {
+		g, err := getGameFromRedis(redisClient, id)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, g)
+	}
+	return games, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"testing"
+	"time"
+)
+
+// newTestRedis starts an in-memory redis server and returns it with a client of it
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, redis.NewClient(&redis.Options{Addr: s.Addr()})
+}
+
+func TestCommitGameResult(t *testing.T) {
+	const playerX, playerO = "player#x", "player#o"
+	prior := &rating{Rating: defaultRating, Deviation: defaultDeviation, Volatility: defaultVolatility}
+	rated := func(score float64) (*rating, *rating) {
+		return updateRating(prior, prior, score), updateRating(prior, prior, 1-score)
+	}
+
+	tests := []struct {
+		name    string
+		outcome string
+		winner  string
+		score   float64 // of X in a rated game; negative if the game is not rated
+		// stats of X and O afterwards as won, lost, draw
+		statsX [3]string
+		statsO [3]string
+	}{
+		{
+			name:    "X wins",
+			outcome: outcomeWonX,
+			winner:  playerX,
+			score:   scoreWin,
+			statsX:  [3]string{"1", "", ""},
+			statsO:  [3]string{"", "1", ""},
+		},
+		{
+			name:    "O wins",
+			outcome: outcomeWonO,
+			winner:  playerO,
+			score:   scoreLoss,
+			statsX:  [3]string{"", "1", ""},
+			statsO:  [3]string{"1", "", ""},
+		},
+		{
+			name:    "draw",
+			outcome: outcomeDraw,
+			score:   scoreDraw,
+			statsX:  [3]string{"", "", "1"},
+			statsO:  [3]string{"", "", "1"},
+		},
+		{
+			name:    "X leaves",
+			outcome: outcomeAbandoned,
+			winner:  playerO,
+			score:   scoreLoss,
+			statsX:  [3]string{"", "1", ""},
+			statsO:  [3]string{"1", "", ""},
+		},
+		{
+			name:    "unrated win",
+			outcome: outcomeWonX,
+			winner:  playerX,
+			score:   -1,
+			statsX:  [3]string{"1", "", ""},
+			statsO:  [3]string{"", "1", ""},
+		},
+		{name: "never played", outcome: outcomeAbandoned, score: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, redisClient := newTestRedis(t)
+			defer s.Close()
+			err := createGameRecord(redisClient, "g1", playerX, playerO, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := &gameResult{
+				GameID:  "g1",
+				PlayerX: playerX,
+				PlayerO: playerO,
+				Outcome: tt.outcome,
+				Winner:  tt.winner,
+			}
+			if tt.score >= 0 {
+				result.RatingX, result.RatingO = rated(tt.score)
+				result.PriorX, result.PriorO = prior, prior
+			}
+
+			// committing again changes nothing
+			for i := 0; i < 2; i++ {
+				err = commitGameResult(redisClient, result)
+				if err != nil {
+					t.Fatalf("commitGameResult() error = %v", err)
+				}
+			}
+
+			for playerID, want := range map[string][3]string{playerX: tt.statsX, playerO: tt.statsO} {
+				got, err := redisClient.HMGet(playerID, "won", "lost", "draw").Result()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, field := range []string{"won", "lost", "draw"} {
+					s, _ := got[i].(string)
+					if s != want[i] {
+						t.Errorf("%s %s = %q, want %q", playerID, field, s, want[i])
+					}
+				}
+			}
+
+			record, err := getGameFromRedis(redisClient, "g1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.State != gameStateOver || record.Outcome != tt.outcome || record.Winner != tt.winner {
+				t.Errorf("record = %s %s %q, want %s %s %q",
+					record.State, record.Outcome, record.Winner, gameStateOver, tt.outcome, tt.winner)
+			}
+
+			ratings, err := redisClient.LLen(getPlayerRatingsKey(playerX)).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := int64(0)
+			if tt.score >= 0 {
+				want = 1
+			}
+			if ratings != want {
+				t.Errorf("rating history of X has %d entries, want %d", ratings, want)
+			}
+		})
+	}
+}
+
+func TestCommitGameResultRatingChanged(t *testing.T) {
+	s, redisClient := newTestRedis(t)
+	defer s.Close()
+	err := createGameRecord(redisClient, "g1", "player#x", "player#o", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// X finished another game after this one was rated
+	err = redisClient.HMSet("player#x", map[string]interface{}{
+		"rating":     1520,
+		"deviation":  300,
+		"volatility": defaultVolatility,
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prior := &rating{Rating: defaultRating, Deviation: defaultDeviation, Volatility: defaultVolatility}
+	err = commitGameResult(redisClient, &gameResult{
+		GameID:  "g1",
+		PlayerX: "player#x",
+		PlayerO: "player#o",
+		Outcome: outcomeWonX,
+		Winner:  "player#x",
+		RatingX: updateRating(prior, prior, scoreWin),
+		RatingO: updateRating(prior, prior, scoreLoss),
+		PriorX:  prior,
+		PriorO:  prior,
+	})
+	if err != errRatingChanged {
+		t.Fatalf("commitGameResult() error = %v, want %v", err, errRatingChanged)
+	}
+	outcome, err := redisClient.HGet(getGameKey("g1"), "outcome").Result()
+	if err != redis.Nil {
+		t.Errorf("outcome = %q, %v, want none", outcome, err)
+	}
+}
+
+func TestCommitGameResultStreaks(t *testing.T) {
+	s, redisClient := newTestRedis(t)
+	defer s.Close()
+	// X won five games in a row before this week
+	err := redisClient.HSet("player#x", "streak", 5).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := func(gameID, outcome, winner string) {
+		err := createGameRecord(redisClient, gameID, "player#x", "player#o", false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := getPlayerFromRedis(redisClient, "player#x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := getPlayerFromRedis(redisClient, "player#o")
+		if err != nil {
+			t.Fatal(err)
+		}
+		priorX, priorO := x.rating(), o.rating()
+		scoreX := scoreLoss
+		if winner == "player#x" {
+			scoreX = scoreWin
+		}
+		err = commitGameResult(redisClient, &gameResult{
+			GameID:  gameID,
+			PlayerX: "player#x",
+			PlayerO: "player#o",
+			Outcome: outcome,
+			Winner:  winner,
+			RatingX: updateRating(priorX, priorO, scoreX),
+			RatingO: updateRating(priorO, priorX, 1-scoreX),
+			PriorX:  priorX,
+			PriorO:  priorO,
+		})
+		if err != nil {
+			t.Fatalf("commitGameResult() error = %v", err)
+		}
+	}
+	streaks := func() []float64 {
+		scores := make([]float64, 0, len(leaderboardWindows))
+		for _, key := range getLeaderboardKeys(leaderboardStreak, time.Now()) {
+			score, err := redisClient.ZScore(key, "player#x").Result()
+			if err != nil && err != redis.Nil {
+				t.Fatal(err)
+			}
+			scores = append(scores, score)
+		}
+		return scores
+	}
+
+	commit("g1", outcomeWonX, "player#x")
+	commit("g2", outcomeWonX, "player#x")
+	// all-time, weekly and monthly
+	if got, want := streaks(), []float64{7, 2, 2}; !equalFloats(got, want) {
+		t.Errorf("streaks = %v, want %v", got, want)
+	}
+
+	// leaving a game ends the streak but the best one stays on the boards
+	commit("g3", outcomeAbandoned, "player#o")
+	commit("g4", outcomeWonX, "player#x")
+	if got, want := streaks(), []float64{7, 2, 2}; !equalFloats(got, want) {
+		t.Errorf("streaks = %v, want %v", got, want)
+	}
+	streak, err := redisClient.HGet("player#x", "streak").Result()
+	if err != nil || streak != "1" {
+		t.Errorf("streak = %q, %v, want 1", streak, err)
+	}
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
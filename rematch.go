@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+// how long a rematch offer stands before the offering player goes back to the lobby
+const rematchTimeout = 15 * time.Second
+
+// OfferRematch offers the last opponent another game. An offer the opponent has already made is accepted instead
+func (p *player) OfferRematch() error {
+	if p.info.State != playerStateGameOver || p.opponent == nil {
+		return errors.New("you can only offer a rematch after a game")
+	}
+	if p.seriesID != "" {
+		return errors.New("the next game of the series starts by itself")
+	}
+	if p.rematchPending {
+		return p.AcceptRematch()
+	}
+	if p.rematchOffered {
+		return nil
+	}
+
+	// Example payload: REMATCH:::myid
+	err := p.PublishMessageToGameChannel(playerRematch(p.info.ID))
+	if err != nil {
+		return errors.Wrap(err, "failed to publish rematch offer")
+	}
+	p.rematchOffered = true
+	var timer *time.Timer
+	timer = p.after(rematchTimeout, func() {
+		// the offer was answered, or withdrawn and made again, in the meantime
+		if p.rematchTimer == timer {
+			p.ExpireRematch()
+		}
+	})
+	p.rematchTimer = timer
+	return nil
+}
+
+// AcceptRematch takes up the opponent's offer and starts the next game
+func (p *player) AcceptRematch() error {
+	if p.info.State != playerStateGameOver || !p.rematchPending {
+		return errors.New("there is no rematch offer to accept")
+	}
+	// Example payload: ACCEPTREMATCH:::myid
+	err := p.PublishMessageToGameChannel(playerAcceptRematch(p.info.ID))
+	if err != nil {
+		return errors.Wrap(err, "failed to publish rematch acceptance")
+	}
+	p.ClearRematch()
+	p.RestartGame()
+	return nil
+}
+
+// DeclineRematch turns down the opponent's offer and takes the player back to the lobby
+func (p *player) DeclineRematch() error {
+	if p.info.State != playerStateGameOver || !p.rematchPending {
+		return errors.New("there is no rematch offer to decline")
+	}
+	// Example payload: DECLINEREMATCH:::myid
+	err := p.PublishMessageToGameChannel(playerDeclineRematch(p.info.ID))
+	if err != nil {
+		return errors.Wrap(err, "failed to publish rematch refusal")
+	}
+	p.ClearRematch()
+	p.ExitGameAndPublish()
+	return nil
+}
+
+// RematchOffered handles an offer from the opponent. Players who offered each other a rematch play it
+func (p *player) RematchOffered() {
+	if p.info.State != playerStateGameOver || p.seriesID != "" {
+		return
+	}
+	if p.rematchOffered {
+		p.ClearRematch()
+		p.RestartGame()
+		return
+	}
+	p.rematchPending = true
+	p.WriteJSON(&message{
+		Type:    messageRematch,
+		Payload: p.opponent,
+	})
+}
+
+// RematchAccepted starts the game the opponent agreed to
+func (p *player) RematchAccepted() {
+	if p.info.State != playerStateGameOver || !p.rematchOffered {
+		return
+	}
+	p.ClearRematch()
+	p.RestartGame()
+}
+
+// RematchDeclined tells the client that the opponent turned down the rematch or no longer waits for an answer
+func (p *player) RematchDeclined() {
+	if !p.rematchOffered && !p.rematchPending {
+		return
+	}
+	p.ClearRematch()
+	p.WriteJSON(&message{
+		Type:    messageDeclineRematch,
+		Payload: "Opponent",
+	})
+}
+
+// ExpireRematch withdraws an offer that was not answered in time and takes the player back to the lobby. It runs
+// in the player's loop
+func (p *player) ExpireRematch() {
+	if !p.rematchOffered || p.info.State != playerStateGameOver {
+		return
+	}
+	p.ClearRematch()
+	p.WriteError(p.PublishMessageToGameChannel(playerDeclineRematch(p.info.ID)))
+	p.WriteJSON(&message{
+		Type:    messageDeclineRematch,
+		Payload: "Timeout",
+	})
+	p.ExitGameAndPublish()
+}
+
+// ClearRematch forgets any rematch offer made by either player
+func (p *player) ClearRematch() {
+	if p.rematchTimer != nil {
+		p.rematchTimer.Stop()
+		p.rematchTimer = nil
+	}
+	p.rematchOffered = false
+	p.rematchPending = false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/rand"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const (
+	// letters and digits that cannot be mistaken for one another when read out
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	roomCodeLength   = 6
+	roomCodeAttempts = 5
+
+	// how long an invite code can be used
+	roomTTL = 10 * time.Minute
+	// codes are kept a little longer in redis so that the host is always told its room expired
+	roomExpiryGrace = time.Minute
+)
+
+// room is sent to the host of a private room
+type room struct {
+	Code string
+	// path of the page that joins the room; clients prefix it with their origin
+	Invite string
+	// unix time in milliseconds after which the code can no longer be used
+	ExpiresAt int64
+}
+
+// getRoomKey returns the key holding the id of the player who opened the room with the given code
+func getRoomKey(code string) string {
+	return "room:" + code
+}
+
+func newRoomCode() (string, error) {
+	b := make([]byte, roomCodeLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate room code")
+	}
+	for i := range b {
+		b[i] = roomCodeAlphabet[int(b[i])%len(roomCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+func normalizeRoomCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// OpenRoom opens a private room and sends the client an invite code. Whoever joins with the code plays the
+// host straight away
+func (p *player) OpenRoom() error {
+	if p.info.State != playerStateFree {
+		return errors.New("you can only open a room while free")
+	}
+
+	code := ""
+	for i := 0; i < roomCodeAttempts && code == ""; i++ {
+		c, err := newRoomCode()
+		if err != nil {
+			return err
+		}
+		ok, err := p.redisClient.SetNX(getRoomKey(c), p.info.ID, roomTTL+roomExpiryGrace).Result()
+		if err != nil {
+			return errors.Wrap(err, "failed to save room")
+		}
+		if ok {
+			code = c
+		}
+	}
+	if code == "" {
+		return errors.New("failed to find a free room code")
+	}
+	p.room = code
+	p.info.State = playerStateHosting
+
+	p.after(roomTTL, func() { p.ExpireRoom(code) })
+
+	return p.WriteJSON(&message{
+		Type: messageRoom,
+		Payload: &room{
+			Code:      code,
+			Invite:    "/?room=" + code,
+			ExpiresAt: millis(time.Now().Add(roomTTL)),
+		},
+	})
+}
+
+// releaseRoom deletes the room with the given code if the player still hosts it. It reports whether it did
+func (p *player) releaseRoom(code string) (bool, error) {
+	i, err := releaseLockScript.Run(p.redisClient, []string{getRoomKey(code)}, p.info.ID).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to close room")
+	}
+	return i == 1, nil
+}
+
+// CloseRoom closes the player's private room unless someone has already joined it
+func (p *player) CloseRoom() error {
+	if p.info.State != playerStateHosting {
+		return errors.New("you have no open room")
+	}
+	ok, err := p.releaseRoom(p.room)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("someone has already joined your room")
+	}
+	p.room = ""
+	p.info.State = playerStateFree
+	return nil
+}
+
+// ExpireRoom closes a room that nobody joined in time and tells the client. It runs in the player's loop
+func (p *player) ExpireRoom(code string) {
+	// the room was closed, or the player has opened another one, in the meantime
+	if p.info.State != playerStateHosting || p.room != code {
+		return
+	}
+	ok, err := p.releaseRoom(code)
+	if err != nil {
+		logError(err)
+		return
+	}
+	// the room was joined or closed in the meantime
+	if !ok {
+		return
+	}
+	p.room = ""
+	p.info.State = playerStateFree
+	p.WriteJSON(&message{
+		Type:    messageRoomExpired,
+		Payload: code,
+	})
+}
+
+// JoinRoom starts a private game against the player who opened the room with the given code. The host plays X
+func (p *player) JoinRoom(code string) error {
+	if p.info.State != playerStateFree {
+		return errors.New("you can only join a room while free")
+	}
+	code = normalizeRoomCode(code)
+	key := getRoomKey(code)
+	hostID, err := p.redisClient.Get(key).Result()
+	if err == redis.Nil {
+		return errors.New("the room does not exist or has expired")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get room")
+	}
+	if hostID == p.info.ID {
+		return errors.New("you cannot join your own room")
+	}
+	in, err := inLobby(p.redisClient, hostID)
+	if err != nil {
+		return err
+	}
+	if !in {
+		return errors.New("the host of the room is not online")
+	}
+
+	// claim the room so that nobody else joins it
+	i, err := releaseLockScript.Run(p.redisClient, []string{key}, hostID).Int64()
+	if err != nil {
+		return errors.Wrap(err, "failed to claim room")
+	}
+	if i == 0 {
+		return errors.New("the room does not exist or has expired")
+	}
+
+	gameID, err := newGameID()
+	if err != nil {
+		return err
+	}
+	// the record is created before the players hear of the game so that it never shows up as live
+	err = createGameRecord(p.redisClient, gameID, hostID, p.info.ID, true, nil)
+	if err != nil {
+		return err
+	}
+	p.info.State = playerStateRequesting
+	p.private = true
+	return publishMatch(p.redisClient, gameID, hostID, p.info.ID)
+}
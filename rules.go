@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// game variants; each is a game with its own rules
+	variantClassic     = "classic"
+	variantUltimate    = "ultimate"
+	variantConnectFour = "connectfour"
+)
+
+// rules are a two player board game the server can host. Games register their rules in gameRules so that
+// players can pick them when they challenge someone; the lobby, challenges, pub/sub, records and stats work the
+// same for every game. A game's board is its state: it lists the legal moves, applies a move and tells when
+// the game is over and who won
+type rules interface {
+	// Validate fills in defaults and checks the settings of a challenge to play the game
+	Validate(s *gameSettings) error
+	// NewBoard returns the initial state of a game played with the given settings
+	NewBoard(s *gameSettings) gameBoard
+}
+
+// gameRules are the games players may choose from, by variant
+var gameRules = map[string]rules{
+	variantClassic:     classicRules{},
+	variantUltimate:    ultimateRules{},
+	variantConnectFour: connectFourRules{},
+}
+
+// variants returns the names of the registered games in alphabetical order
+func variants() []string {
+	names := make([]string, 0, len(gameRules))
+	for name := range gameRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getRules(variant string) (rules, error) {
+	r, ok := gameRules[variant]
+	if !ok {
+		return nil, errors.Errorf("unknown variant %q, expected one of %v", variant, variants())
+	}
+	return r, nil
+}
+
+// boardSnapshot is implemented by the states sent to clients so that built-in bots can rebuild the board
+type boardSnapshot interface {
+	// restore returns the board of the snapshot and the mark of the player it was sent to
+	restore() (gameBoard, string)
+}
+
+// botMover is implemented by boards that pick the moves of built-in bots their own way. Bots search the moves
+// of other boards a few moves ahead
+type botMover interface {
+	BotMove(mark, level string, rnd *rand.Rand) string
+}
+
+// classicRules are tic-tac-toe on a square board of any size, won with a number of marks in a row
+type classicRules struct{}
+
+// Validate fills in a 3x3 board unless asked otherwise, won with as many marks in a row as it has rows, up to
+// five, and checks the rule modifier
+func (classicRules) Validate(s *gameSettings) error {
+	if s.Size == 0 {
+		s.Size = defaultBoardSize
+	}
+	if s.Size < minBoardSize || s.Size > maxBoardSize {
+		return errors.Errorf("board size must be between %d and %d", minBoardSize, maxBoardSize)
+	}
+	if s.WinLength == 0 {
+		s.WinLength = s.Size
+		if s.WinLength > maxDefaultWinLength {
+			s.WinLength = maxDefaultWinLength
+		}
+	}
+	if s.WinLength < minWinLength || s.WinLength > s.Size {
+		return errors.Errorf("win length must be between %d and the board size", minWinLength)
+	}
+	return validateModifier(s)
+}
+
+func (classicRules) NewBoard(s *gameSettings) gameBoard {
+	b := newBoard(s.Size, s.WinLength)
+	b.modifier = s.Modifier
+	return b
+}
+
+// ultimateRules are ultimate tic-tac-toe
+type ultimateRules struct{}
+
+// Validate records the size of the board; every ultimate game is played on nine 3x3 sub-boards
+func (ultimateRules) Validate(s *gameSettings) error {
+	s.Size, s.WinLength = ultimateBoardSize, ultimateWinLength
+	return rejectModifier(s)
+}
+
+func (ultimateRules) NewBoard(s *gameSettings) gameBoard {
+	return newUltimateBoard()
+}
+
+// connectFourRules are Connect Four
+type connectFourRules struct{}
+
+// Validate records the size of the board; every game is played on seven columns and won with four in a row
+func (connectFourRules) Validate(s *gameSettings) error {
+	s.Size, s.WinLength = connectFourColumns, connectFourWinLength
+	return rejectModifier(s)
+}
+
+func (connectFourRules) NewBoard(s *gameSettings) gameBoard {
+	return newConnectFourBoard()
+}
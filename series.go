@@ -0,0 +1,237 @@
+package main
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// series states
+	seriesStatePlaying   = "PLAYING"
+	seriesStateOver      = "OVER"
+	seriesStateAbandoned = "ABANDONED"
+
+	// pause between the games of a series so that players can see how the last one ended
+	seriesPause = 3 * time.Second
+)
+
+// series is a best-of-N match between two players. A win is worth a point and a draw half a point to each
+// player; the series is over once a player has more than half of the points or all games have been played
+type series struct {
+	ID      string
+	BestOf  int
+	PlayerA string
+	PlayerB string
+	ScoreA  float64
+	ScoreB  float64
+	Games   []string
+	State   string
+	// empty for a drawn series
+	Winner    string
+	StartedAt int64
+	EndedAt   int64
+}
+
+// getSeriesKey returns the hash of a series; series have the id of their first game
+func getSeriesKey(id string) string {
+	return "series:" + id
+}
+
+func getSeriesGamesKey(id string) string {
+	return "series:" + id + ":games"
+}
+
+func createSeries(redisClient *redis.Client, id string, bestOf int, playerA, playerB string) error {
+	return errors.Wrap(
+		redisClient.HMSet(getSeriesKey(id), map[string]interface{}{
+			"id":        id,
+			"bestOf":    bestOf,
+			"playerA":   playerA,
+			"playerB":   playerB,
+			"scoreA":    0,
+			"scoreB":    0,
+			"state":     seriesStatePlaying,
+			"startedAt": time.Now().Unix(),
+		}).Err(),
+		"failed to create series",
+	)
+}
+
+// recordSeriesGameScript adds the result of a game to its series and ends the series once it is decided.
+// Each game is only counted once, so running the script again for the same game does nothing.
+//
+// KEYS: series key, series games key
+// ARGV: game id, winner id or empty for a draw, end time
+var recordSeriesGameScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "state") ~= "PLAYING" then
+	return 0
+end
+if redis.call("HSETNX", KEYS[1], "game:" .. ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("RPUSH", KEYS[2], ARGV[1])
+local a = redis.call("HGET", KEYS[1], "playerA")
+local b = redis.call("HGET", KEYS[1], "playerB")
+if ARGV[2] == a then
+	redis.call("HINCRBYFLOAT", KEYS[1], "scoreA", 1)
+elseif ARGV[2] == b then
+	redis.call("HINCRBYFLOAT", KEYS[1], "scoreB", 1)
+else
+	redis.call("HINCRBYFLOAT", KEYS[1], "scoreA", 0.5)
+	redis.call("HINCRBYFLOAT", KEYS[1], "scoreB", 0.5)
+end
+local scoreA = tonumber(redis.call("HGET", KEYS[1], "scoreA"))
+local scoreB = tonumber(redis.call("HGET", KEYS[1], "scoreB"))
+local bestOf = tonumber(redis.call("HGET", KEYS[1], "bestOf"))
+if scoreA > bestOf / 2 or scoreB > bestOf / 2 or redis.call("LLEN", KEYS[2]) >= bestOf then
+	local winner = ""
+	if scoreA > scoreB then
+		winner = a
+	elseif scoreB > scoreA then
+		winner = b
+	end
+	redis.call("HMSET", KEYS[1], "state", "OVER", "winner", winner, "endedAt", ARGV[3])
+end
+return 1
+`)
+
+func recordSeriesGame(redisClient *redis.Client, id, gameID, winner string) error {
+	return errors.Wrapf(
+		recordSeriesGameScript.Run(
+			redisClient, []string{getSeriesKey(id), getSeriesGamesKey(id)}, gameID, winner, time.Now().Unix(),
+		).Err(),
+		"failed to record game %s of series %s", gameID, id,
+	)
+}
+
+// abandonSeriesScript ends a series that one of the players left; the player who stayed wins it
+//
+// KEYS: series key
+// ARGV: winner id, end time
+var abandonSeriesScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "state") ~= "PLAYING" then
+	return 0
+end
+redis.call("HMSET", KEYS[1], "state", "ABANDONED", "winner", ARGV[1], "endedAt", ARGV[2])
+return 1
+`)
+
+func abandonSeries(redisClient *redis.Client, id, winner string) error {
+	return errors.Wrapf(
+		abandonSeriesScript.Run(redisClient, []string{getSeriesKey(id)}, winner, time.Now().Unix()).Err(),
+		"failed to abandon series %s", id,
+	)
+}
+
+func getSeriesFromRedis(redisClient *redis.Client, id string) (*series, error) {
+	seriesMap, err := redisClient.HGetAll(getSeriesKey(id)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get series from map")
+	}
+	if seriesMap["id"] == "" {
+		return nil, errors.Errorf("series %s not found", id)
+	}
+	games, err := redisClient.LRange(getSeriesGamesKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get series games")
+	}
+	s := &series{
+		ID:      seriesMap["id"],
+		PlayerA: seriesMap["playerA"],
+		PlayerB: seriesMap["playerB"],
+		Games:   games,
+		State:   seriesMap["state"],
+		Winner:  seriesMap["winner"],
+	}
+	s.BestOf, err = strconv.Atoi(seriesMap["bestOf"])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert bestOf to int")
+	}
+	s.ScoreA, err = strconv.ParseFloat(seriesMap["scoreA"], 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert scoreA to float")
+	}
+	s.ScoreB, err = strconv.ParseFloat(seriesMap["scoreB"], 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert scoreB to float")
+	}
+	if seriesMap["startedAt"] != "" {
+		s.StartedAt, err = strconv.ParseInt(seriesMap["startedAt"], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert startedAt to int")
+		}
+	}
+	if seriesMap["endedAt"] != "" {
+		s.EndedAt, err = strconv.ParseInt(seriesMap["endedAt"], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert endedAt to int")
+		}
+	}
+	return s, nil
+}
+
+// SeriesGameOver sends the client the score of its series and starts the next game unless the series is over
+func (p *player) SeriesGameOver() error {
+	s, err := getSeriesFromRedis(p.redisClient, p.seriesID)
+	if err != nil {
+		return err
+	}
+	err = p.WriteJSON(&message{Type: messageSeries, Payload: s})
+	if err != nil {
+		return err
+	}
+	if s.State != seriesStatePlaying {
+		// players may still agree on a rematch, which is a game of its own
+		p.seriesID = ""
+		return nil
+	}
+
+	gameID := p.gameID
+	p.after(seriesPause, func() {
+		// the opponent may have left in the meantime
+		if p.info.State != playerStateGameOver || p.gameID != gameID {
+			return
+		}
+		p.RestartGame()
+	})
+	return nil
+}
+
+// GetSeries returns a best-of-N series with its score and games.
+// Example: GET /series/9f2c41d07ab3e5f6
+func (g *game) GetSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/series/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "missing series id", http.StatusBadRequest)
+		return
+	}
+
+	exist, err := g.redisClient.Exists(getSeriesKey(id)).Result()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exist == 0 {
+		http.Error(w, "series not found", http.StatusNotFound)
+		return
+	}
+
+	s, err := getSeriesFromRedis(g.redisClient, id)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, s)
+}
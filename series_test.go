@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRecordSeriesGame(t *testing.T) {
+	const playerA, playerB = "player#a", "player#b"
+
+	tests := []struct {
+		name   string
+		bestOf int
+		// winner of each game in order; empty for a draw
+		winners []string
+		state   string
+		winner  string
+		scoreA  float64
+		scoreB  float64
+	}{
+		{
+			name:    "under way",
+			bestOf:  3,
+			winners: []string{playerA},
+			state:   seriesStatePlaying,
+			scoreA:  1,
+		},
+		{
+			name:    "won before all games are played",
+			bestOf:  3,
+			winners: []string{playerA, playerA},
+			state:   seriesStateOver,
+			winner:  playerA,
+			scoreA:  2,
+		},
+		{
+			name:    "won in the last game",
+			bestOf:  3,
+			winners: []string{playerA, playerB, playerB},
+			state:   seriesStateOver,
+			winner:  playerB,
+			scoreA:  1,
+			scoreB:  2,
+		},
+		{
+			name:    "won on half points",
+			bestOf:  3,
+			winners: []string{"", playerB, ""},
+			state:   seriesStateOver,
+			winner:  playerB,
+			scoreA:  1,
+			scoreB:  2,
+		},
+		{
+			name:    "drawn",
+			bestOf:  2,
+			winners: []string{playerA, playerB},
+			state:   seriesStateOver,
+			scoreA:  1,
+			scoreB:  1,
+		},
+		{
+			name:    "games after the end are not counted",
+			bestOf:  3,
+			winners: []string{playerB, playerB, playerA},
+			state:   seriesStateOver,
+			winner:  playerB,
+			scoreB:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, redisClient := newTestRedis(t)
+			defer s.Close()
+			err := createSeries(redisClient, "s1", tt.bestOf, playerA, playerB)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a series has the id of its first game
+			gameID := "s1"
+			for _, winner := range tt.winners {
+				// a game counts once however often it is recorded
+				for i := 0; i < 2; i++ {
+					err = recordSeriesGame(redisClient, "s1", gameID, winner)
+					if err != nil {
+						t.Fatalf("recordSeriesGame() error = %v", err)
+					}
+				}
+				gameID = nextGameID(gameID)
+			}
+
+			sr, err := getSeriesFromRedis(redisClient, "s1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sr.State != tt.state || sr.Winner != tt.winner {
+				t.Errorf("series = %s %q, want %s %q", sr.State, sr.Winner, tt.state, tt.winner)
+			}
+			if sr.ScoreA != tt.scoreA || sr.ScoreB != tt.scoreB {
+				t.Errorf("score = %v-%v, want %v-%v", sr.ScoreA, sr.ScoreB, tt.scoreA, tt.scoreB)
+			}
+		})
+	}
+}
+
+func TestAbandonSeries(t *testing.T) {
+	s, redisClient := newTestRedis(t)
+	defer s.Close()
+	err := createSeries(redisClient, "s1", 5, "player#a", "player#b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = recordSeriesGame(redisClient, "s1", "s1", "player#a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the player who stayed wins even when behind; a series only ends once
+	for _, winner := range []string{"player#b", "player#a"} {
+		err = abandonSeries(redisClient, "s1", winner)
+		if err != nil {
+			t.Fatalf("abandonSeries() error = %v", err)
+		}
+	}
+	err = recordSeriesGame(redisClient, "s1", nextGameID("s1"), "player#a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sr, err := getSeriesFromRedis(redisClient, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.State != seriesStateAbandoned || sr.Winner != "player#b" || len(sr.Games) != 1 {
+		t.Errorf("series = %s %q after %d games, want %s %q after 1",
+			sr.State, sr.Winner, len(sr.Games), seriesStateAbandoned, "player#b")
+	}
+}
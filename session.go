@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// how long a session is kept while its client is connected; refreshed whenever a game starts or ends
+const sessionTTL = 24 * time.Hour
+
+func getSessionKey(token string) string {
+	return "session:" + token
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate session token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewSession creates a session that the client can use to resume its game after losing the connection
+func (p *player) NewSession() error {
+	token, err := newSessionToken()
+	if err != nil {
+		return err
+	}
+	err = p.redisClient.HMSet(getSessionKey(token), map[string]interface{}{
+		"playerID": p.info.ID,
+		"gameID":   p.gameID,
+	}).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to save session")
+	}
+	err = p.redisClient.Expire(getSessionKey(token), sessionTTL).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to set session expiry")
+	}
+	p.session = token
+	return nil
+}
+
+// SendSession sends the session token to the client
+func (p *player) SendSession() error {
+	return p.WriteJSON(&message{Type: messageSession, Payload: p.session})
+}
+
+// SaveSessionGame saves the game the player is in on its session
+func (p *player) SaveSessionGame() error {
+	if p.session == "" {
+		return nil
+	}
+	err := p.redisClient.HSet(getSessionKey(p.session), "gameID", p.gameID).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to save session game")
+	}
+	return errors.Wrap(
+		p.redisClient.Expire(getSessionKey(p.session), sessionTTL).Err(),
+		"failed to set session expiry",
+	)
+}
+
+func (p *player) DeleteSession() error {
+	if p.session == "" {
+		return nil
+	}
+	return errors.Wrap(p.redisClient.Del(getSessionKey(p.session)).Err(), "failed to delete session")
+}
+
+// HoldGame keeps the player's game open for the resume grace period after the client's connection is lost.
+// It returns once the session has been resumed or the grace period is over.
+func (p *player) HoldGame() {
+	if p.game.resumeGrace <= 0 || p.session == "" || p.gameID == "" {
+		return
+	}
+	if p.info.State != playerStatePlaying && p.info.State != playerStateGameOver {
+		return
+	}
+	// the session only outlives the connection for the grace period
+	logError(p.redisClient.Expire(getSessionKey(p.session), p.game.resumeGrace).Err())
+
+	select {
+	case <-p.handedOff:
+	case <-p.ctx.Done():
+	case <-time.After(p.game.resumeGrace):
+	}
+}
+
+// HandOff gives up the player's game to the connection that resumed its session
+func (p *player) HandOff() {
+	select {
+	case <-p.handedOff:
+		return
+	default:
+		close(p.handedOff)
+	}
+	p.cancel()
+	// stop reading from the old connection if it is still open
+	logError(p.conn.Close())
+}
+
+func (p *player) HandedOff() bool {
+	select {
+	case <-p.handedOff:
+		return true
+	default:
+		return false
+	}
+}
+
+// ResumePlayer picks up the game of a client that reconnects with its session token.
+// It returns false if the session has no game to resume so that the client can join as a new player.
+func (g *game) ResumePlayer(w http.ResponseWriter, r *http.Request, token string) bool {
+	sessionMap, err := g.redisClient.HGetAll(getSessionKey(token)).Result()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	playerID, gameID := sessionMap["playerID"], sessionMap["gameID"]
+	if playerID == "" || gameID == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+
+	p := &player{
+		ctx:         ctx,
+		cancel:      cancel,
+		mu:          &sync.Mutex{},
+		game:        g,
+		redisClient: g.redisClient,
+		waitingChan: make(chan struct{}, 0),
+		handedOff:   make(chan struct{}, 0),
+		requests:    make(chan *message),
+		events:      make(chan func()),
+		gameID:      gameID,
+	}
+
+	// everything opened below is closed again unless the player starts running
+	running := false
+	defer func() {
+		if running {
+			return
+		}
+		cancel()
+		p.Unsubscribe()
+		if p.conn != nil {
+			logError(p.conn.Close())
+		}
+	}()
+
+	p.info, err = getPlayerFromRedis(g.redisClient, playerID)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	// subscribe before loading the game so that no move is missed in between
+	err = p.Subscribe()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	record, err := getGameFromRedis(g.redisClient, gameID)
+	if err != nil || record.Outcome == outcomeAbandoned {
+		// the game is gone; the client joins afresh
+		logError(err)
+		return false
+	}
+
+	opponentID := record.PlayerO
+	p.mark = markX
+	if record.PlayerO == playerID {
+		opponentID = record.PlayerX
+		p.mark = markO
+	}
+
+	p.opponent, err = getPlayerFromRedis(g.redisClient, opponentID)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	// rematches are played on the same board
+	p.settings = record.settings()
+	p.board = p.settings.newBoard()
+	err = p.board.Replay(record.Moves)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+
+	p.private = record.Private
+	if record.Series != "" {
+		s, err := getSeriesFromRedis(g.redisClient, record.Series)
+		if err != nil {
+			logError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+		if s.State == seriesStatePlaying {
+			p.seriesID = s.ID
+		}
+	}
+
+	p.info.State = playerStatePlaying
+	if record.State == gameStateOver {
+		// waiting for a restart or exit
+		p.info.State = playerStateGameOver
+	}
+
+	// upgrade connection to websocket before anything is taken from the old connection
+	p.conn, err = upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logError(err)
+		return true
+	}
+
+	// tokens are used once; the resumed connection gets a new one
+	err = p.NewSession()
+	if err != nil {
+		logError(err)
+		return true
+	}
+	logError(g.redisClient.Del(getSessionKey(token)).Err())
+
+	// tell the node holding the game that it has been taken over
+	err = g.redisClient.Publish(playerID, playerResumed(token)).Err()
+	if err != nil {
+		logError(err)
+		return true
+	}
+
+	// send the player, its session, the opponent and the board back to the client
+	err = p.WriteErrors(
+		p.WriteJSON(&message{Type: messageWelcome, Payload: p.info}),
+		p.SendSession(),
+		p.WriteJSON(&message{Type: messagePlayerStartGame, Payload: p.opponent}),
+		p.SendBoard(),
+	)
+	if err != nil {
+		// the game was handed off to this connection, so it is left from here
+		logError(p.LeaveGame())
+		return true
+	}
+
+	running = true
+	p.Run()
+	return true
+}
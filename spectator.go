@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+)
+
+// spectator watches a live game. It receives the moves and result of the game but cannot play
+type spectator struct {
+	ctx         context.Context
+	cancel      func()
+	mu          *sync.Mutex // guards conn
+	conn        *websocket.Conn
+	redisClient *redis.Client
+	gameID      string
+	board       gameBoard
+}
+
+// spectatorSnapshot is sent when a spectator joins a game
+type spectatorSnapshot struct {
+	Game  *gameRecord
+	Board interface{}
+}
+
+func (g *game) SpectatorJoin(w http.ResponseWriter, r *http.Request) {
+	gameID := r.URL.Query().Get("game")
+	if gameID == "" {
+		http.Error(w, "missing game query parameter", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	s := &spectator{
+		ctx:         ctx,
+		cancel:      cancel,
+		mu:          &sync.Mutex{},
+		redisClient: g.redisClient,
+		gameID:      gameID,
+	}
+
+	// subscribe before taking the snapshot so that no move is missed in between
+	pubSub := s.redisClient.Subscribe(getGameChannel(gameID))
+	defer pubSub.Close()
+	_, err := pubSub.Receive()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	record, err := getGameFromRedis(s.redisClient, gameID)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// games started from an invite code are only for the two players
+	if record.Private {
+		http.Error(w, "game is private", http.StatusForbidden)
+		return
+	}
+	if record.State != gameStatePlaying {
+		http.Error(w, "game is over", http.StatusGone)
+		return
+	}
+
+	s.board = record.settings().newBoard()
+	err = s.board.Replay(record.Moves)
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// upgrade connection to websocket
+	s.conn, err = upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logError(err)
+		return
+	}
+	defer s.conn.Close()
+
+	// send the current state of the game
+	err = s.WriteJSON(&message{
+		Type:    messageSpectate,
+		Payload: &spectatorSnapshot{Game: record, Board: s.board.State(markNone)},
+	})
+	if err != nil {
+		return
+	}
+
+	go s.ReadConn()
+	s.ReadChannel(pubSub)
+}
+
+// ReadConn drains messages from the spectator; spectators are not allowed to take part in the game
+func (s *spectator) ReadConn() {
+	msg := new(message)
+	for {
+		err := s.conn.ReadJSON(msg)
+		if err != nil {
+			s.cancel()
+			return
+		}
+		s.WriteJSON(&message{
+			Type:    messageErrorHappened,
+			Payload: "spectators cannot send game actions",
+		})
+	}
+}
+
+// ReadChannel forwards the game's moves and result to the spectator until the game is over
+func (s *spectator) ReadChannel(pubSub *redis.PubSub) {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case msg := <-pubSub.Channel():
+			broadMessageType, payload := fromBroadCast(msg.Payload)
+			switch broadMessageType {
+			case messagePlayerMove:
+				// moves already in the snapshot are not sent again
+				number, moveID := fromMoveBroadCast(payload)
+				if number <= s.board.Moves() {
+					break
+				}
+				err := s.board.Play(s.board.Turn(), moveID)
+				if err != nil {
+					logError(err)
+					break
+				}
+				s.WriteJSON(&message{
+					Type:    messagePlayerMove,
+					Payload: s.board.MovePayload(moveID),
+				})
+			case messageGameWon, messageGameDraw, messagePlayerExitGame:
+				s.WriteJSON(&message{
+					Type:    broadMessageType,
+					Payload: payload,
+				})
+				return
+			}
+		}
+	}
+}
+
+func (s *spectator) WriteJSON(msg *message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.conn.WriteJSON(msg)
+	logError(err)
+	return err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// how many opponents pairing a swiss round may try before it settles for a rematch
+const maxSwissPairingSteps = 1000
+
+// swissRounds returns the number of rounds of a swiss tournament. Unless set when the tournament was created
+// there are as many rounds as it takes for one player to stay unbeaten. Either way there are never more rounds
+// than it takes for everyone to have met everyone
+func swissRounds(t *tournament) int {
+	rounds := t.Rounds
+	if rounds == 0 {
+		rounds = 1
+		for 1<<uint(rounds) < len(t.Seeds) {
+			rounds++
+		}
+	}
+	if max := roundRobinRounds(len(t.Seeds)); rounds > max {
+		rounds = max
+	}
+	return rounds
+}
+
+// swissRound pairs the next round of a swiss tournament. Players on equal points meet where possible and
+// nobody meets the same opponent twice. It returns no pairings once the last round has been played
+func swissRound(redisClient *redis.Client, t *tournament) ([]*pairing, error) {
+	if len(t.Seeds) < 2 || t.Round >= swissRounds(t) {
+		return nil, nil
+	}
+
+	met := make(map[string]map[string]bool, len(t.Seeds))
+	hadBye := make(map[string]bool, len(t.Seeds))
+	// how many more games each player has moved first in than second, and whether it moved first last time
+	balance := make(map[string]int, len(t.Seeds))
+	last := make(map[string]int, len(t.Seeds))
+	for round := 1; round <= t.Round; round++ {
+		pairings, _, err := getRoundPairings(redisClient, t.ID, round)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range pairings {
+			if pr.Second == "" {
+				hadBye[pr.First] = true
+				continue
+			}
+			for _, pair := range [][2]string{{pr.First, pr.Second}, {pr.Second, pr.First}} {
+				if met[pair[0]] == nil {
+					met[pair[0]] = make(map[string]bool)
+				}
+				met[pair[0]][pair[1]] = true
+			}
+			balance[pr.First]++
+			balance[pr.Second]--
+			last[pr.First], last[pr.Second] = 1, -1
+		}
+	}
+
+	members, err := redisClient.ZRangeWithScores(getTournamentStandingsKey(t.ID), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tournament standings")
+	}
+	points := make(map[string]float64, len(members))
+	for _, member := range members {
+		points[member.Member.(string)] = member.Score
+	}
+
+	// highest points first; seeds decide between players on equal points
+	players := append([]string{}, t.Seeds...)
+	sort.SliceStable(players, func(i, j int) bool {
+		return points[players[i]] > points[players[j]]
+	})
+
+	bye := ""
+	if len(players)%2 == 1 {
+		// the lowest placed player who has not had a bye yet sits the round out
+		i := len(players) - 1
+		for j := len(players) - 1; j >= 0; j-- {
+			if !hadBye[players[j]] {
+				i = j
+				break
+			}
+		}
+		bye = players[i]
+		players = append(players[:i:i], players[i+1:]...)
+	}
+
+	pairs := pairSwiss(players, met)
+	if pairs == nil {
+		// there is no way around a rematch, or none was found in time
+		pairs = pairGreedy(players, met)
+	}
+
+	pairings := make([]*pairing, 0, len(pairs)+1)
+	for i, pair := range pairs {
+		first, second := swissColours(pair[0], pair[1], balance, last)
+		pairings = append(pairings, newPairing(i+1, first, second))
+	}
+	if bye != "" {
+		pairings = append(pairings, newPairing(len(pairings)+1, bye, ""))
+	}
+	return pairings, nil
+}
+
+// swissColours returns the two players of a pairing in the order they move in. The player who has moved first
+// less often moves first; between players who have done so equally often, the one who moved second last time
+// does. Otherwise the higher placed player, a, moves first
+func swissColours(a, b string, balance, last map[string]int) (string, string) {
+	if balance[b] < balance[a] || (balance[b] == balance[a] && last[b] < last[a]) {
+		return b, a
+	}
+	return a, b
+}
+
+// pairSwiss pairs players in order, each with the highest placed player below it that it has not met.
+// It returns nil if they cannot all be paired that way, or if no way was found within maxSwissPairingSteps
+func pairSwiss(players []string, met map[string]map[string]bool) [][2]string {
+	steps := maxSwissPairingSteps
+	return searchSwiss(players, met, &steps)
+}
+
+// searchSwiss backs out of an opponent that leaves the players below unpaired and tries the next one, until
+// it runs out of steps
+func searchSwiss(players []string, met map[string]map[string]bool, steps *int) [][2]string {
+	if len(players) == 0 {
+		return [][2]string{}
+	}
+	first := players[0]
+	for i := 1; i < len(players) && *steps > 0; i++ {
+		if met[first][players[i]] {
+			continue
+		}
+		*steps--
+		rest := make([]string, 0, len(players)-2)
+		rest = append(rest, players[1:i]...)
+		rest = append(rest, players[i+1:]...)
+		pairs := searchSwiss(rest, met, steps)
+		if pairs != nil {
+			return append([][2]string{{first, players[i]}}, pairs...)
+		}
+	}
+	return nil
+}
+
+// pairGreedy pairs players in order, each with the highest placed player below it that it has not met, or with
+// the highest placed one if it has met them all
+func pairGreedy(players []string, met map[string]map[string]bool) [][2]string {
+	left := append([]string{}, players...)
+	pairs := make([][2]string, 0, len(players)/2)
+	for len(left) >= 2 {
+		j := 1
+		for k := 1; k < len(left); k++ {
+			if !met[left[0]][left[k]] {
+				j = k
+				break
+			}
+		}
+		pairs = append(pairs, [2]string{left[0], left[j]})
+		left = append(left[1:j], left[j+1:]...)
+	}
+	return pairs
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// metPairs returns who has met whom given pairs of players
+func metPairs(pairs ...[2]string) map[string]map[string]bool {
+	met := make(map[string]map[string]bool)
+	for _, pair := range pairs {
+		for _, p := range [][2]string{pair, {pair[1], pair[0]}} {
+			if met[p[0]] == nil {
+				met[p[0]] = make(map[string]bool)
+			}
+			met[p[0]][p[1]] = true
+		}
+	}
+	return met
+}
+
+func TestSwissRounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		players int
+		rounds  int
+		want    int
+	}{
+		{name: "two players", players: 2, want: 1},
+		{name: "three players", players: 3, want: 2},
+		{name: "eight players", players: 8, want: 3},
+		{name: "nine players", players: 9, want: 4},
+		{name: "set by the organizer", players: 8, rounds: 5, want: 5},
+		{name: "more than everyone meeting everyone", players: 4, rounds: 10, want: 3},
+		{name: "more than everyone meeting everyone with a bye", players: 5, rounds: 10, want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &tournament{Rounds: tt.rounds, Seeds: make([]string, tt.players)}
+			if got := swissRounds(tr); got != tt.want {
+				t.Errorf("swissRounds() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPairSwiss(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []string
+		met     map[string]map[string]bool
+		want    [][2]string
+	}{
+		{
+			name:    "first round",
+			players: []string{"a", "b", "c", "d"},
+			met:     metPairs(),
+			want:    [][2]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:    "next player down instead of a rematch",
+			players: []string{"a", "b", "c", "d"},
+			met:     metPairs([2]string{"a", "b"}, [2]string{"c", "d"}),
+			want:    [][2]string{{"a", "c"}, {"b", "d"}},
+		},
+		{
+			name:    "backs out of a pairing that leaves a rematch below",
+			players: []string{"a", "b", "c", "d"},
+			met:     metPairs([2]string{"a", "b"}, [2]string{"b", "d"}),
+			want:    [][2]string{{"a", "d"}, {"b", "c"}},
+		},
+		{
+			name:    "only rematches left",
+			players: []string{"a", "b"},
+			met:     metPairs([2]string{"a", "b"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pairSwiss(tt.players, tt.met); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pairSwiss() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPairSwissGivesUp(t *testing.T) {
+	// the last player has met everyone, which the search only finds out once it gets to the bottom of every order
+	players := make([]string, 0, 30)
+	for i := 0; i < 30; i++ {
+		players = append(players, string(rune('A'+i)))
+	}
+	last := players[len(players)-1]
+	pairs := make([][2]string, 0, len(players)-1)
+	for _, playerID := range players[:len(players)-1] {
+		pairs = append(pairs, [2]string{playerID, last})
+	}
+	met := metPairs(pairs...)
+
+	if got := pairSwiss(players, met); got != nil {
+		t.Errorf("pairSwiss() = %v, want nil", got)
+	}
+	if got := pairGreedy(players, met); len(got) != len(players)/2 {
+		t.Errorf("pairGreedy() paired %d, want %d", len(got), len(players)/2)
+	}
+}
+
+func TestPairGreedy(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []string
+		met     map[string]map[string]bool
+		want    [][2]string
+	}{
+		{
+			name:    "no rematch",
+			players: []string{"a", "b", "c", "d"},
+			met:     metPairs([2]string{"a", "b"}),
+			want:    [][2]string{{"a", "c"}, {"b", "d"}},
+		},
+		{
+			name:    "rematch with the highest placed player when there is no one else",
+			players: []string{"a", "b", "c", "d"},
+			met:     metPairs([2]string{"a", "b"}, [2]string{"a", "c"}, [2]string{"a", "d"}),
+			want:    [][2]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:    "rematch left at the bottom",
+			players: []string{"a", "b", "c", "d"},
+			met: metPairs(
+				[2]string{"a", "b"}, [2]string{"c", "d"}, [2]string{"a", "c"}, [2]string{"b", "d"},
+			),
+			want: [][2]string{{"a", "d"}, {"b", "c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pairGreedy(tt.players, tt.met); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pairGreedy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwissColours(t *testing.T) {
+	tests := []struct {
+		name    string
+		balance map[string]int
+		last    map[string]int
+		first   string
+	}{
+		{name: "first round", first: "a"},
+		{
+			name:    "moved first more often",
+			balance: map[string]int{"a": 1, "b": -1},
+			last:    map[string]int{"a": 1, "b": -1},
+			first:   "b",
+		},
+		{
+			name:    "moved second more often",
+			balance: map[string]int{"a": -1, "b": 1},
+			last:    map[string]int{"a": -1, "b": 1},
+			first:   "a",
+		},
+		{
+			name:    "moved first last time",
+			balance: map[string]int{"a": 0, "b": 0},
+			last:    map[string]int{"a": 1, "b": -1},
+			first:   "b",
+		},
+		{
+			name:    "moved second last time",
+			balance: map[string]int{"a": 0, "b": 0},
+			last:    map[string]int{"a": -1, "b": 1},
+			first:   "a",
+		},
+		{
+			name:    "same history",
+			balance: map[string]int{"a": 1, "b": 1},
+			last:    map[string]int{"a": 1, "b": 1},
+			first:   "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := swissColours("a", "b", tt.balance, tt.last)
+			if first != tt.first || second == first {
+				t.Errorf("swissColours() = %s, %s, want %s first", first, second, tt.first)
+			}
+		})
+	}
+}
@@ -0,0 +1,1226 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sorted set of tournament ids scored by creation time
+	tournamentsZSet = "zset:tournaments"
+	// set of tournaments being played; every node drives them
+	runningTournamentsSet = "set:tournaments:running"
+
+	// tournament formats
+	formatRoundRobin  = "roundrobin"
+	formatElimination = "elimination"
+	formatSwiss       = "swiss"
+	formatArena       = "arena"
+
+	// tournament states
+	tournamentStateRegistering = "REGISTERING"
+	tournamentStateRunning     = "RUNNING"
+	tournamentStateFinished    = "FINISHED"
+
+	// pairing states
+	pairingStatePending = "PENDING"
+	pairingStatePlaying = "PLAYING"
+	pairingStateDone    = "DONE"
+
+	// how often nodes start, score and advance the games of running tournaments
+	tournamentInterval = time.Second
+	// how long a paired player has to be in the lobby for its game to start before it forfeits
+	tournamentNoShow = 2 * time.Minute
+	// how long a tournament game may wait for its first move before it is abandoned and nobody scores
+	tournamentStartDeadline = 2 * time.Minute
+	// how many games a drawn elimination pairing is played before the higher seed goes through
+	maxEliminationGames = 3
+
+	maxTournamentNameLength = 40
+	maxSwissRounds          = 15
+	maxArenaMinutes         = 24 * 60
+
+	pointsWin  = 1.0
+	pointsDraw = 0.5
+)
+
+var tournamentFormats = []string{formatRoundRobin, formatElimination, formatSwiss, formatArena}
+
+var (
+	errRegistrationClosed = errors.New("registration for the tournament is closed")
+	errTournamentStarted  = errors.New("the tournament has started already")
+	errTooFewPlayers      = errors.New("a tournament needs at least two players")
+)
+
+// tournament is a tournament as stored in redis
+type tournament struct {
+	ID        string
+	Name      string
+	Format    string
+	State     string
+	Round     int
+	CreatedAt int64
+	StartedAt int64
+	EndedAt   int64
+	Winner    string
+	// players from the highest to the lowest seed; set when the first round is paired
+	Seeds []string
+	// number of rounds of a swiss tournament; worked out from the number of players if zero
+	Rounds int
+	// length of an arena and the unix time at which it ends once started
+	Minutes int
+	EndsAt  int64
+}
+
+// pairing is a match between two players in a round of a tournament.
+// Drawn elimination pairings are played again with the players swapping marks
+type pairing struct {
+	Number int
+	// plays X in the first game; the higher seed in elimination
+	First string
+	// empty for a bye
+	Second string
+	State  string
+	Games  []string
+	Winner string
+	// unix time in milliseconds since when the pairing has been waiting for its next game, or playing it
+	Since int64
+}
+
+type standing struct {
+	Rank   int
+	Player string
+	Name   string
+	Points float64
+}
+
+// tournamentView is a tournament together with its players, standings and rounds
+type tournamentView struct {
+	*tournament
+	Players   []string
+	Standings []*standing
+	Rounds    [][]*pairing
+}
+
+func getTournamentKey(id string) string {
+	return "tournament:" + id
+}
+
+// getTournamentPlayersKey returns the sorted set of registered players scored by registration time
+func getTournamentPlayersKey(id string) string {
+	return "zset:tournament:" + id + ":players"
+}
+
+// getTournamentStandingsKey returns the sorted set of registered players scored by points
+func getTournamentStandingsKey(id string) string {
+	return "zset:tournament:" + id + ":standings"
+}
+
+// getTournamentRoundKey returns the hash of the pairings of a round keyed by their number
+func getTournamentRoundKey(id string, round int) string {
+	return "hash:tournament:" + id + ":round:" + strconv.Itoa(round)
+}
+
+func newTournamentID() (string, error) {
+	b := make([]byte, 6)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate tournament id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func validateTournament(signup *tournamentSignup) error {
+	if signup.Name == "" || len(signup.Name) > maxTournamentNameLength {
+		return errors.Errorf("name must be between 1 and %d characters long", maxTournamentNameLength)
+	}
+	if !contains(tournamentFormats, signup.Format) {
+		return errors.Errorf("unknown tournament format %q", signup.Format)
+	}
+	if signup.Rounds < 0 || signup.Rounds > maxSwissRounds {
+		return errors.Errorf("rounds must be between 0 and %d", maxSwissRounds)
+	}
+	if signup.Format == formatArena && (signup.Minutes <= 0 || signup.Minutes > maxArenaMinutes) {
+		return errors.Errorf("an arena must last between 1 and %d minutes", maxArenaMinutes)
+	}
+	return nil
+}
+
+func createTournament(redisClient *redis.Client, signup *tournamentSignup) (*tournament, error) {
+	err := validateTournament(signup)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newTournamentID()
+	if err != nil {
+		return nil, err
+	}
+	t := &tournament{
+		ID:        id,
+		Name:      signup.Name,
+		Format:    signup.Format,
+		State:     tournamentStateRegistering,
+		CreatedAt: time.Now().Unix(),
+		Rounds:    signup.Rounds,
+		Minutes:   signup.Minutes,
+	}
+	_, err = redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(getTournamentKey(id), map[string]interface{}{
+			"id":        t.ID,
+			"name":      t.Name,
+			"format":    t.Format,
+			"state":     t.State,
+			"round":     t.Round,
+			"createdAt": t.CreatedAt,
+			"rounds":    t.Rounds,
+			"minutes":   t.Minutes,
+		})
+		pipe.ZAdd(tournamentsZSet, redis.Z{Member: id, Score: float64(t.CreatedAt)})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create tournament")
+	}
+	return t, nil
+}
+
+func getTournament(redisClient *redis.Client, id string) (*tournament, error) {
+	tournamentMap, err := redisClient.HGetAll(getTournamentKey(id)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tournament from map")
+	}
+	if tournamentMap["id"] == "" {
+		return nil, errors.Errorf("tournament %s not found", id)
+	}
+	t := &tournament{
+		ID:     tournamentMap["id"],
+		Name:   tournamentMap["name"],
+		Format: tournamentMap["format"],
+		State:  tournamentMap["state"],
+		Winner: tournamentMap["winner"],
+	}
+	for field, v := range map[string]*int{
+		"round":   &t.Round,
+		"rounds":  &t.Rounds,
+		"minutes": &t.Minutes,
+	} {
+		if tournamentMap[field] == "" {
+			continue
+		}
+		*v, err = strconv.Atoi(tournamentMap[field])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to convert %s to int", field)
+		}
+	}
+	for field, v := range map[string]*int64{
+		"createdAt": &t.CreatedAt,
+		"startedAt": &t.StartedAt,
+		"endedAt":   &t.EndedAt,
+		"endsAt":    &t.EndsAt,
+	} {
+		if tournamentMap[field] == "" {
+			continue
+		}
+		*v, err = strconv.ParseInt(tournamentMap[field], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to convert %s to int", field)
+		}
+	}
+	if tournamentMap["seeds"] != "" {
+		err = json.Unmarshal([]byte(tournamentMap["seeds"]), &t.Seeds)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode seeds")
+		}
+	}
+	return t, nil
+}
+
+// getTournaments returns a page of tournaments, newest first
+func getTournaments(redisClient *redis.Client, offset, limit int64) ([]*tournament, error) {
+	ids, err := redisClient.ZRevRange(tournamentsZSet, offset, offset+limit-1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tournaments")
+	}
+	tournaments := make([]*tournament, 0, len(ids))
+	for _, id := range ids {
+		t, err := getTournament(redisClient, id)
+		if err != nil {
+			return nil, err
+		}
+		tournaments = append(tournaments, t)
+	}
+	return tournaments, nil
+}
+
+// getRoundPairings returns the pairings of a round in order together with the raw values they were decoded from.
+// The finished pairings of an arena are not among them
+func getRoundPairings(redisClient *redis.Client, id string, round int) ([]*pairing, []string, error) {
+	return getPairings(redisClient, getTournamentRoundKey(id, round))
+}
+
+// getPairings returns the pairings saved in a hash in order together with the raw values they were decoded from
+func getPairings(redisClient *redis.Client, key string) ([]*pairing, []string, error) {
+	pairingsMap, err := redisClient.HGetAll(key).Result()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get round pairings")
+	}
+	pairings := make([]*pairing, 0, len(pairingsMap))
+	raws := make(map[*pairing]string, len(pairingsMap))
+	for _, raw := range pairingsMap {
+		pr := &pairing{}
+		err = json.Unmarshal([]byte(raw), pr)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to decode pairing")
+		}
+		pairings = append(pairings, pr)
+		raws[pr] = raw
+	}
+	sort.Slice(pairings, func(i, j int) bool {
+		return pairings[i].Number < pairings[j].Number
+	})
+	ordered := make([]string, 0, len(pairings))
+	for _, pr := range pairings {
+		ordered = append(ordered, raws[pr])
+	}
+	return pairings, ordered, nil
+}
+
+func getTournamentView(redisClient *redis.Client, id string) (*tournamentView, error) {
+	t, err := getTournament(redisClient, id)
+	if err != nil {
+		return nil, err
+	}
+	view := &tournamentView{tournament: t}
+
+	view.Players, err = redisClient.ZRange(getTournamentPlayersKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tournament players")
+	}
+
+	members, err := redisClient.ZRevRangeWithScores(getTournamentStandingsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get tournament standings")
+	}
+	view.Standings = make([]*standing, 0, len(members))
+	for i, member := range members {
+		info, err := getPlayerFromRedis(redisClient, member.Member.(string))
+		if err != nil {
+			return nil, err
+		}
+		s := &standing{Rank: i + 1, Player: info.ID, Name: info.Name, Points: member.Score}
+		// players on equal points share a rank
+		if i > 0 && member.Score == members[i-1].Score {
+			s.Rank = view.Standings[i-1].Rank
+		}
+		view.Standings = append(view.Standings, s)
+	}
+
+	view.Rounds = make([][]*pairing, 0, t.Round)
+	for round := 1; round <= t.Round; round++ {
+		pairings, _, err := getRoundPairings(redisClient, id, round)
+		if err != nil {
+			return nil, err
+		}
+		if t.Format == formatArena {
+			finished, _, err := getPairings(redisClient, getArenaFinishedKey(id, round))
+			if err != nil {
+				return nil, err
+			}
+			pairings = append(finished, pairings...)
+			sort.Slice(pairings, func(i, j int) bool {
+				return pairings[i].Number < pairings[j].Number
+			})
+		}
+		view.Rounds = append(view.Rounds, pairings)
+	}
+	return view, nil
+}
+
+// registerTournamentScript adds a player to a tournament that has not started yet, or to an arena that is
+// being played. It returns -1 if registration is closed and 0 if the player was registered already
+//
+// KEYS: tournament key, players key, standings key
+// ARGV: player id, registration time
+var registerTournamentScript = redis.NewScript(`
+local state = redis.call("HGET", KEYS[1], "state")
+local late = state == "RUNNING" and redis.call("HGET", KEYS[1], "format") == "arena"
+if state ~= "REGISTERING" and not late then
+	return -1
+end
+if redis.call("ZSCORE", KEYS[2], ARGV[1]) then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
+redis.call("ZADD", KEYS[3], 0, ARGV[1])
+return 1
+`)
+
+// withdrawTournamentScript takes a player out of a tournament that has not started yet. It returns -1 if
+// registration is closed
+//
+// KEYS: tournament key, players key, standings key
+// ARGV: player id
+var withdrawTournamentScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "state") ~= "REGISTERING" then
+	return -1
+end
+redis.call("ZREM", KEYS[3], ARGV[1])
+return redis.call("ZREM", KEYS[2], ARGV[1])
+`)
+
+func tournamentKeys(id string) []string {
+	return []string{getTournamentKey(id), getTournamentPlayersKey(id), getTournamentStandingsKey(id)}
+}
+
+func joinTournament(redisClient *redis.Client, id, playerID string) error {
+	i, err := registerTournamentScript.Run(redisClient, tournamentKeys(id), playerID, millis(time.Now())).Int64()
+	if err != nil {
+		return errors.Wrap(err, "failed to register for tournament")
+	}
+	if i < 0 {
+		return errRegistrationClosed
+	}
+	return publishTournamentUpdate(redisClient, id)
+}
+
+func leaveTournament(redisClient *redis.Client, id, playerID string) error {
+	i, err := withdrawTournamentScript.Run(redisClient, tournamentKeys(id), playerID).Int64()
+	if err != nil {
+		return errors.Wrap(err, "failed to withdraw from tournament")
+	}
+	if i < 0 {
+		return errTournamentStarted
+	}
+	return publishTournamentUpdate(redisClient, id)
+}
+
+// setTournamentFieldScript sets a field of a tournament only if it still has the expected value, together with
+// any other fields given. This lets every node drive a tournament without two of them making the same change
+//
+// KEYS: tournament key
+// ARGV: field, expected value, new value, then pairs of other fields and values
+var setTournamentFieldScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+for i = 4, #ARGV, 2 do
+	redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+return 1
+`)
+
+func setTournamentField(redisClient *redis.Client, id, field, expected, value string, others ...interface{}) (bool, error) {
+	args := append([]interface{}{field, expected, value}, others...)
+	i, err := setTournamentFieldScript.Run(redisClient, []string{getTournamentKey(id)}, args...).Int64()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to set tournament %s", field)
+	}
+	return i == 1, nil
+}
+
+// startTournamentScript closes registration of a tournament with at least two players and adds it to the
+// running tournaments. Players register and withdraw with scripts of their own, so the count cannot change
+// before the tournament starts. An arena has a single round that lasts until the arena ends.
+// It returns -1 if the tournament has started already and 0 if it has too few players
+//
+// KEYS: tournament key, players key, running tournaments key
+// ARGV: tournament id, start time
+var startTournamentScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "state") ~= "REGISTERING" then
+	return -1
+end
+if redis.call("ZCARD", KEYS[2]) < 2 then
+	return 0
+end
+redis.call("HMSET", KEYS[1], "state", "RUNNING", "startedAt", ARGV[2])
+if redis.call("HGET", KEYS[1], "format") == "arena" then
+	local minutes = tonumber(redis.call("HGET", KEYS[1], "minutes"))
+	redis.call("HMSET", KEYS[1], "round", 1, "endsAt", tonumber(ARGV[2]) + minutes * 60)
+end
+redis.call("SADD", KEYS[3], ARGV[1])
+return 1
+`)
+
+// startTournament closes registration. The first round is paired by the next node to drive the tournament
+func startTournament(redisClient *redis.Client, id string) error {
+	i, err := startTournamentScript.Run(
+		redisClient, []string{getTournamentKey(id), getTournamentPlayersKey(id), runningTournamentsSet},
+		id, time.Now().Unix(),
+	).Int64()
+	if err != nil {
+		return errors.Wrap(err, "failed to start tournament")
+	}
+	switch i {
+	case -1:
+		return errTournamentStarted
+	case 0:
+		return errTooFewPlayers
+	}
+	return publishTournamentUpdate(redisClient, id)
+}
+
+// publishTournamentUpdate tells every node that the standings of a tournament have changed
+func publishTournamentUpdate(redisClient *redis.Client, id string) error {
+	return errors.Wrap(
+		redisClient.Publish(playersChannel, tournamentUpdated(id)).Err(),
+		"failed to publish tournament update",
+	)
+}
+
+// updatePairingScript replaces a pairing only if nobody has changed it in the meantime and awards points
+// to the players of a finished pairing in the same step
+//
+// KEYS: round key, standings key
+// ARGV: pairing number, expected pairing, new pairing, then pairs of player ids and points
+var updatePairingScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+for i = 4, #ARGV, 2 do
+	redis.call("ZINCRBY", KEYS[2], ARGV[i + 1], ARGV[i])
+end
+return 1
+`)
+
+func updatePairing(redisClient *redis.Client, t *tournament, raw string, next *pairing, points map[string]float64) (bool, error) {
+	bs, err := json.Marshal(next)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to encode pairing")
+	}
+	args := []interface{}{next.Number, raw, string(bs)}
+	for playerID, p := range points {
+		args = append(args, playerID, p)
+	}
+	i, err := updatePairingScript.Run(
+		redisClient, []string{getTournamentRoundKey(t.ID, t.Round), getTournamentStandingsKey(t.ID)}, args...,
+	).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to update pairing")
+	}
+	return i == 1, nil
+}
+
+// newPairing returns a pairing waiting for its first game. A player without an opponent gets a bye
+func newPairing(number int, first, second string) *pairing {
+	pr := &pairing{
+		Number: number,
+		First:  first,
+		Second: second,
+		State:  pairingStatePending,
+		Games:  []string{},
+		Since:  millis(time.Now()),
+	}
+	if second == "" {
+		pr.State = pairingStateDone
+		pr.Winner = first
+	}
+	return pr
+}
+
+// roundRobinRounds returns the number of rounds in which every player meets every other player once
+func roundRobinRounds(players int) int {
+	if players%2 == 1 {
+		return players
+	}
+	return players - 1
+}
+
+// roundRobinPairings pairs the players for a round of a round-robin using the circle method: the first player
+// keeps its seat while the others move one seat along each round. Rounds start at 1
+func roundRobinPairings(seeds []string, round int) []*pairing {
+	players := append([]string{}, seeds...)
+	if len(players)%2 == 1 {
+		// whoever sits here has a bye
+		players = append(players, "")
+	}
+	n := len(players)
+
+	seats := make([]string, n)
+	seats[0] = players[0]
+	for i := 1; i < n; i++ {
+		seats[1+(i-1+round-1)%(n-1)] = players[i]
+	}
+
+	pairings := make([]*pairing, 0, n/2)
+	for i := 0; i < n/2; i++ {
+		first, second := seats[i], seats[n-1-i]
+		// share out moving first: the player who keeps its seat alternates between rounds, and every other player
+		// alternates as it moves from seat to seat
+		swap := i%2 == 1
+		if i == 0 {
+			swap = round%2 == 0
+		}
+		if swap {
+			first, second = second, first
+		}
+		if first == "" {
+			first, second = second, first
+		}
+		pairings = append(pairings, newPairing(i+1, first, second))
+	}
+	return pairings
+}
+
+// bracketOrder returns the seeds of a bracket of the given size in the order they are paired, so that the
+// top seeds can only meet in the last rounds. Example: 8 -> 1 8 4 5 2 7 3 6
+func bracketOrder(size int) []int {
+	order := []int{1}
+	for n := 2; n <= size; n *= 2 {
+		next := make([]int, 0, n)
+		for _, seed := range order {
+			next = append(next, seed, n+1-seed)
+		}
+		order = next
+	}
+	return order
+}
+
+// eliminationPairings pairs the first round of a single elimination bracket. When the number of players is
+// not a power of two the top seeds get byes
+func eliminationPairings(seeds []string) []*pairing {
+	size := 2
+	for size < len(seeds) {
+		size *= 2
+	}
+	order := bracketOrder(size)
+
+	pairings := make([]*pairing, 0, size/2)
+	for i := 0; i < size/2; i++ {
+		first, second := seeds[order[2*i]-1], ""
+		if order[2*i+1] <= len(seeds) {
+			second = seeds[order[2*i+1]-1]
+		}
+		pairings = append(pairings, newPairing(i+1, first, second))
+	}
+	return pairings
+}
+
+// nextRound returns the pairings of the round after the given one. It returns no pairings and the winner,
+// if there is one, when the tournament is over
+func nextRound(t *tournament, pairings []*pairing) ([]*pairing, string) {
+	if len(t.Seeds) < 2 {
+		if len(t.Seeds) == 1 {
+			return nil, t.Seeds[0]
+		}
+		return nil, ""
+	}
+
+	switch t.Format {
+	case formatRoundRobin:
+		if t.Round >= roundRobinRounds(len(t.Seeds)) {
+			// the winner is the leader of the standings
+			return nil, ""
+		}
+		return roundRobinPairings(t.Seeds, t.Round+1), ""
+	case formatElimination:
+		if t.Round == 0 {
+			return eliminationPairings(t.Seeds), ""
+		}
+		if len(pairings) == 1 {
+			return nil, pairings[0].Winner
+		}
+		seedOf := make(map[string]int, len(t.Seeds))
+		for i, playerID := range t.Seeds {
+			seedOf[playerID] = i
+		}
+		next := make([]*pairing, 0, len(pairings)/2)
+		for i := 0; i+1 < len(pairings); i += 2 {
+			first, second := pairings[i].Winner, pairings[i+1].Winner
+			if seedOf[second] < seedOf[first] {
+				first, second = second, first
+			}
+			next = append(next, newPairing(len(next)+1, first, second))
+		}
+		return next, ""
+	}
+	return nil, ""
+}
+
+// runTournaments drives the running tournaments until the node stops
+func (g *game) runTournaments() {
+	ticker := time.NewTicker(tournamentInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids, err := g.redisClient.SMembers(runningTournamentsSet).Result()
+		if err != nil {
+			logError(err)
+			continue
+		}
+		for _, id := range ids {
+			logError(g.DriveTournament(id))
+		}
+	}
+}
+
+// DriveTournament starts the games of paired players, scores finished games and pairs the next round once
+// every game of the current one is over. Every node drives every running tournament; changes are only made
+// if nobody else made them first
+func (g *game) DriveTournament(id string) error {
+	t, err := getTournament(g.redisClient, id)
+	if err != nil {
+		return err
+	}
+	if t.State != tournamentStateRunning {
+		return errors.Wrap(g.redisClient.SRem(runningTournamentsSet, id).Err(), "failed to remove tournament")
+	}
+
+	pairings, raws, err := getRoundPairings(g.redisClient, id, t.Round)
+	if err != nil {
+		return err
+	}
+
+	changed, over := false, true
+	for i, pr := range pairings {
+		ok, err := g.drivePairing(t, pr, raws[i])
+		if err != nil {
+			return err
+		}
+		changed = changed || ok
+		if pr.State != pairingStateDone {
+			over = false
+		}
+	}
+
+	switch {
+	case t.Format == formatArena:
+		// arenas pair players as soon as they are free rather than round by round
+		ok, err := g.PairArena(t, over)
+		if err != nil {
+			return err
+		}
+		changed = changed || ok
+	case over:
+		ok, err := advanceTournament(g.redisClient, t, pairings)
+		if err != nil {
+			return err
+		}
+		changed = changed || ok
+	}
+
+	if !changed {
+		return nil
+	}
+	return publishTournamentUpdate(g.redisClient, id)
+}
+
+// drivePairing moves a pairing on to its next state if it can; pr is updated if it did
+func (g *game) drivePairing(t *tournament, pr *pairing, raw string) (bool, error) {
+	switch pr.State {
+	case pairingStatePending:
+		return g.startPairing(t, pr, raw)
+	case pairingStatePlaying:
+		return scorePairing(g.redisClient, t, pr, raw)
+	}
+	return false, nil
+}
+
+// startPairing starts the next game of a pairing once both players are in the lobby. A player who does not
+// turn up in time forfeits
+func (g *game) startPairing(t *tournament, pr *pairing, raw string) (bool, error) {
+	firstIn, err := inLobby(g.redisClient, pr.First)
+	if err != nil {
+		return false, err
+	}
+	secondIn, err := inLobby(g.redisClient, pr.Second)
+	if err != nil {
+		return false, err
+	}
+
+	// an arena that is over starts no more games
+	closed := t.Format == formatArena && time.Now().Unix() >= t.EndsAt
+
+	next := *pr
+	if closed || !firstIn || !secondIn {
+		if !closed && time.Since(time.Unix(0, pr.Since*int64(time.Millisecond))) < tournamentNoShow {
+			return false, nil
+		}
+		next.State = pairingStateDone
+		switch {
+		case t.Format == formatArena:
+			// nobody scores; whoever is back in the lobby is paired again
+		case firstIn:
+			next.Winner = pr.First
+		case secondIn:
+			next.Winner = pr.Second
+		case t.Format == formatElimination:
+			// somebody has to go through
+			next.Winner = pr.First
+		}
+		if t.Format == formatArena {
+			ok, err := finishArenaPairing(g.redisClient, t, raw, &next, nil, nil)
+			if ok {
+				*pr = next
+			}
+			return ok, err
+		}
+		points := map[string]float64{}
+		if next.Winner != "" {
+			points[next.Winner] = pointsWin
+		}
+		ok, err := updatePairing(g.redisClient, t, raw, &next, points)
+		if ok {
+			*pr = next
+		}
+		return ok, err
+	}
+
+	gameID, err := newGameID()
+	if err != nil {
+		return false, err
+	}
+	next.State = pairingStatePlaying
+	next.Games = append(append([]string{}, pr.Games...), gameID)
+	next.Since = millis(time.Now())
+	ok, err := updatePairing(g.redisClient, t, raw, &next, nil)
+	if err != nil || !ok {
+		return false, err
+	}
+	*pr = next
+
+	// players swap marks after a drawn game
+	playerX, playerO := pr.First, pr.Second
+	if len(pr.Games)%2 == 0 {
+		playerX, playerO = playerO, playerX
+	}
+	logrus.Infoln("tournament game: ", t.ID, playerX, playerO)
+
+	// the players must not be paired by the matchmaker as well
+	err = g.redisClient.ZRem(matchmakingZSet, playerX, playerO).Err()
+	if err != nil {
+		return true, errors.Wrap(err, "failed to take players out of the matchmaking queue")
+	}
+	err = createGameRecord(g.redisClient, gameID, playerX, playerO, false, nil)
+	if err != nil {
+		return true, err
+	}
+	err = g.redisClient.HSet(getGameKey(gameID), "tournament", t.ID).Err()
+	if err != nil {
+		return true, errors.Wrap(err, "failed to save tournament of game")
+	}
+	return true, publishMatch(g.redisClient, gameID, playerX, playerO)
+}
+
+// inLobby reports whether a player is connected and not in a game
+func inLobby(redisClient *redis.Client, playerID string) (bool, error) {
+	err := redisClient.ZScore(playersZSet, playerID).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check if player is in the lobby")
+	}
+	return true, nil
+}
+
+// scorePairing awards the points of a pairing whose game is over. A drawn elimination game is played again
+func scorePairing(redisClient *redis.Client, t *tournament, pr *pairing, raw string) (bool, error) {
+	record, err := getGameFromRedis(redisClient, pr.Games[len(pr.Games)-1])
+	if err != nil {
+		return false, err
+	}
+	if record.State != gameStateOver {
+		record, err = closeUnstartedGame(redisClient, pr, record)
+		if err != nil || record.State != gameStateOver {
+			return false, err
+		}
+	}
+	if t.Format == formatArena {
+		return scoreArenaPairing(redisClient, t, pr, raw, record)
+	}
+
+	next := *pr
+	next.State = pairingStateDone
+	points := map[string]float64{}
+	switch {
+	case record.Winner != "":
+		// abandoned games are won by the player who stayed
+		next.Winner = record.Winner
+		points[next.Winner] = pointsWin
+	case record.Outcome == outcomeAbandoned && t.Format == formatElimination:
+		// a game that never started is lost by both players, but somebody has to go through
+		next.Winner = pr.First
+		points[next.Winner] = pointsWin
+	case record.Outcome == outcomeAbandoned:
+		// a game that never started is lost by both players
+	case t.Format == formatElimination && len(pr.Games) < maxEliminationGames:
+		next.State = pairingStatePending
+		next.Since = millis(time.Now())
+	case t.Format == formatElimination:
+		next.Winner = pr.First
+		points[next.Winner] = pointsWin
+	default:
+		points[pr.First] = pointsDraw
+		points[pr.Second] = pointsDraw
+	}
+
+	ok, err := updatePairing(redisClient, t, raw, &next, points)
+	if ok {
+		*pr = next
+	}
+	return ok, err
+}
+
+// closeUnstartedGame abandons a tournament game nobody has moved in by the start deadline, e.g. because a
+// player's node went away or a player was still listed in the lobby after leaving. Nobody wins the game; its
+// players are sent back to the lobby if they are still waiting in it. The record is returned as it is afterwards
+func closeUnstartedGame(redisClient *redis.Client, pr *pairing, record *gameRecord) (*gameRecord, error) {
+	if len(record.Moves) > 0 || time.Since(time.Unix(0, pr.Since*int64(time.Millisecond))) < tournamentStartDeadline {
+		return record, nil
+	}
+	logrus.Infoln("tournament game not started: ", record.ID)
+	err := commitGameResult(redisClient, &gameResult{
+		GameID:  record.ID,
+		PlayerX: record.PlayerX,
+		PlayerO: record.PlayerO,
+		Outcome: outcomeAbandoned,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, playerID := range []string{record.PlayerX, record.PlayerO} {
+		err = redisClient.Publish(playerID, matchRefused(record.ID)).Err()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to publish abandoned game")
+		}
+	}
+	// a result committed by the players in the meantime is kept
+	return getGameFromRedis(redisClient, record.ID)
+}
+
+// saveRoundScript saves the pairings of a round that no other node has saved yet. The points of a bye are
+// awarded with the pairing, so only the node that saved it awards them
+//
+// KEYS: round key, standings key
+// ARGV: points for a bye, then the number, the pairing and the player on a bye, if any, of each pairing
+var saveRoundScript = redis.NewScript(`
+for i = 2, #ARGV, 3 do
+	if redis.call("HSETNX", KEYS[1], ARGV[i], ARGV[i + 1]) == 1 and ARGV[i + 2] ~= "" then
+		redis.call("ZINCRBY", KEYS[2], ARGV[1], ARGV[i + 2])
+	end
+end
+return 1
+`)
+
+// advanceTournament pairs the round after the current one or finishes the tournament
+func advanceTournament(redisClient *redis.Client, t *tournament, pairings []*pairing) (bool, error) {
+	if t.Round == 0 {
+		err := seedTournament(redisClient, t)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var (
+		next   []*pairing
+		winner string
+		err    error
+	)
+	if t.Format == formatSwiss {
+		next, err = swissRound(redisClient, t)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		next, winner = nextRound(t, pairings)
+	}
+	if len(next) == 0 {
+		return finishTournament(redisClient, t, winner)
+	}
+
+	// nodes pair a round the same way; the first to save a pairing wins
+	args := []interface{}{pointsWin}
+	for _, pr := range next {
+		bs, err := json.Marshal(pr)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to encode pairing")
+		}
+		// a bye is worth a win in swiss
+		bye := ""
+		if t.Format == formatSwiss && pr.Second == "" {
+			bye = pr.First
+		}
+		args = append(args, pr.Number, string(bs), bye)
+	}
+	err = saveRoundScript.Run(
+		redisClient, []string{getTournamentRoundKey(t.ID, t.Round+1), getTournamentStandingsKey(t.ID)}, args...,
+	).Err()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to save pairings")
+	}
+
+	return setTournamentField(redisClient, t.ID, "round", strconv.Itoa(t.Round), strconv.Itoa(t.Round+1))
+}
+
+// seedTournament orders the players of a tournament by rating, highest first
+func seedTournament(redisClient *redis.Client, t *tournament) error {
+	ids, err := redisClient.ZRange(getTournamentPlayersKey(t.ID), 0, -1).Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to get tournament players")
+	}
+	players := make([]*playerInfo, 0, len(ids))
+	for _, id := range ids {
+		info, err := getPlayerFromRedis(redisClient, id)
+		if err != nil {
+			return err
+		}
+		players = append(players, info)
+	}
+	// players who registered first are seeded higher on equal rating
+	sort.SliceStable(players, func(i, j int) bool {
+		return players[i].Rating > players[j].Rating
+	})
+	seeds := make([]string, 0, len(players))
+	for _, info := range players {
+		seeds = append(seeds, info.ID)
+	}
+
+	bs, err := json.Marshal(seeds)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode seeds")
+	}
+	// ratings may change while nodes seed; everyone uses the seeds saved first
+	err = redisClient.HSetNX(getTournamentKey(t.ID), "seeds", string(bs)).Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to save seeds")
+	}
+	saved, err := redisClient.HGet(getTournamentKey(t.ID), "seeds").Result()
+	if err != nil {
+		return errors.Wrap(err, "failed to get seeds")
+	}
+	return errors.Wrap(json.Unmarshal([]byte(saved), &t.Seeds), "failed to decode seeds")
+}
+
+// finishTournament ends a tournament. Without a winner the leader of the standings wins
+func finishTournament(redisClient *redis.Client, t *tournament, winner string) (bool, error) {
+	if winner == "" {
+		leaders, err := redisClient.ZRevRange(getTournamentStandingsKey(t.ID), 0, 0).Result()
+		if err != nil {
+			return false, errors.Wrap(err, "failed to get tournament leader")
+		}
+		if len(leaders) > 0 {
+			winner = leaders[0]
+		}
+	}
+	ok, err := setTournamentField(
+		redisClient, t.ID, "state", tournamentStateRunning, tournamentStateFinished,
+		"winner", winner, "endedAt", time.Now().Unix(),
+	)
+	if err != nil || !ok {
+		return false, err
+	}
+	logrus.Infoln("tournament finished: ", t.ID, winner)
+	return true, errors.Wrap(redisClient.SRem(runningTournamentsSet, t.ID).Err(), "failed to remove tournament")
+}
+
+// JoinTournament registers the player for a tournament and sends it the tournament's standings from now on
+func (p *player) JoinTournament(id string) error {
+	err := joinTournament(p.redisClient, id, p.info.ID)
+	if err != nil {
+		return err
+	}
+	return p.FollowTournament(id)
+}
+
+func (p *player) LeaveTournament(id string) error {
+	err := leaveTournament(p.redisClient, id, p.info.ID)
+	if err != nil {
+		return err
+	}
+	p.following.Delete(id)
+	return nil
+}
+
+// FollowTournament sends the client the standings of a tournament now and whenever they change
+func (p *player) FollowTournament(id string) error {
+	p.following.Store(id, true)
+	return p.SendTournament(id)
+}
+
+func (p *player) Following(id string) bool {
+	_, ok := p.following.Load(id)
+	return ok
+}
+
+func (p *player) SendTournament(id string) error {
+	view, err := getTournamentView(p.redisClient, id)
+	if err != nil {
+		return err
+	}
+	return p.WriteJSON(&message{Type: messageTournament, Payload: view})
+}
+
+type tournamentSignup struct {
+	Name   string
+	Format string
+	// swiss only; zero to work it out from the number of players
+	Rounds int
+	// arena only
+	Minutes int
+}
+
+// tournamentStatus returns the http status for an error of a tournament request
+func tournamentStatus(err error) int {
+	switch errors.Cause(err) {
+	case errRegistrationClosed, errTournamentStarted, errTooFewPlayers:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// authorizeOrganizer checks the organizer key of a request to create or start a tournament. It replies with an
+// error and returns false if the request is not allowed
+func (g *game) authorizeOrganizer(w http.ResponseWriter, r *http.Request) bool {
+	// tournaments can only be organized on servers that have an organizer key
+	if g.organizerKey == "" {
+		http.Error(w, "organizing tournaments is disabled", http.StatusForbidden)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(g.organizerKey)) != 1 {
+		http.Error(w, "invalid organizer key", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// Tournaments returns a page of tournaments, newest first, or creates a tournament. Tournaments are created by
+// organizers, who send the organizer key in an Authorization: Bearer header.
+// Example: GET /tournaments?offset=0&limit=20
+// Example: POST /tournaments {"Name": "Friday Cup", "Format": "elimination"}
+// Example: POST /tournaments {"Name": "Lunch Arena", "Format": "arena", "Minutes": 30}
+func (g *game) Tournaments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		offset, err := queryInt(query.Get("offset"), 0)
+		if err != nil || offset < 0 {
+			http.Error(w, "offset must be zero or more", http.StatusBadRequest)
+			return
+		}
+		limit, err := queryInt(query.Get("limit"), defaultGamesLimit)
+		if err != nil || limit <= 0 || limit > maxGamesLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxGamesLimit), http.StatusBadRequest)
+			return
+		}
+		tournaments, err := getTournaments(g.redisClient, offset, limit)
+		if err != nil {
+			logError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, tournaments)
+	case http.MethodPost:
+		if !g.authorizeOrganizer(w, r) {
+			return
+		}
+		signup := &tournamentSignup{}
+		err := json.NewDecoder(r.Body).Decode(signup)
+		if err != nil {
+			http.Error(w, "malformed request body", http.StatusBadRequest)
+			return
+		}
+		signup.Name = strings.TrimSpace(signup.Name)
+		err = validateTournament(signup)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := createTournament(g.redisClient, signup)
+		if err != nil {
+			logError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, t)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Tournament returns a tournament with its standings and rounds, starts it, or registers or withdraws the
+// player identified by the request's identity token. Like creating one, starting a tournament takes the
+// organizer key.
+// Example: GET /tournaments/5b1f0c2a9d3e
+// Example: POST /tournaments/5b1f0c2a9d3e/start
+// Example: POST /tournaments/5b1f0c2a9d3e/players with an X-Identity-Token: cGxheWVy... header
+// Example: DELETE /tournaments/5b1f0c2a9d3e/players with an X-Identity-Token: cGxheWVy... header
+func (g *game) Tournament(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/tournaments/"), "/")
+	id, action := path[0], ""
+	if len(path) > 1 {
+		action = path[1]
+	}
+	if id == "" || len(path) > 2 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	exist, err := g.redisClient.Exists(getTournamentKey(id)).Result()
+	if err != nil {
+		logError(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if exist == 0 {
+		http.Error(w, "tournament not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		view, err := getTournamentView(g.redisClient, id)
+		if err != nil {
+			logError(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, view)
+	case action == "start" && r.Method == http.MethodPost:
+		if !g.authorizeOrganizer(w, r) {
+			return
+		}
+		err = startTournament(g.redisClient, id)
+		if err != nil {
+			http.Error(w, err.Error(), tournamentStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "players" && (r.Method == http.MethodPost || r.Method == http.MethodDelete):
+		playerID, err := verifyIdentity(g.tokenSecret, identityToken(r))
+		if err != nil {
+			http.Error(w, "invalid identity token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			err = joinTournament(g.redisClient, id, playerID)
+		} else {
+			err = leaveTournament(g.redisClient, id, playerID)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), tournamentStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case action == "" || action == "start" || action == "players":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoundRobinPairings(t *testing.T) {
+	tests := []struct {
+		name  string
+		seeds []string
+	}{
+		{name: "two players", seeds: []string{"a", "b"}},
+		{name: "four players", seeds: []string{"a", "b", "c", "d"}},
+		{name: "five players", seeds: []string{"a", "b", "c", "d", "e"}},
+		{name: "eight players", seeds: []string{"a", "b", "c", "d", "e", "f", "g", "h"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			met := make(map[[2]string]int)
+			byes := make(map[string]int)
+			moved := make(map[string]int)
+			rounds := roundRobinRounds(len(tt.seeds))
+			for round := 1; round <= rounds; round++ {
+				played := make(map[string]bool)
+				for _, pr := range roundRobinPairings(tt.seeds, round) {
+					for _, playerID := range []string{pr.First, pr.Second} {
+						if playerID == "" {
+							continue
+						}
+						if played[playerID] {
+							t.Fatalf("round %d: %s is paired twice", round, playerID)
+						}
+						played[playerID] = true
+					}
+					if pr.First == "" {
+						t.Fatalf("round %d: pairing %d has no first player", round, pr.Number)
+					}
+					if pr.Second == "" {
+						byes[pr.First]++
+						continue
+					}
+					pair := [2]string{pr.First, pr.Second}
+					if pair[0] > pair[1] {
+						pair[0], pair[1] = pair[1], pair[0]
+					}
+					met[pair]++
+					moved[pr.First]++
+				}
+				if len(played) != len(tt.seeds) {
+					t.Errorf("round %d: %d players paired, want %d", round, len(played), len(tt.seeds))
+				}
+			}
+
+			// everyone meets everyone else exactly once and sits out at most once
+			for i, a := range tt.seeds {
+				for _, b := range tt.seeds[i+1:] {
+					if n := met[[2]string{a, b}]; n != 1 {
+						t.Errorf("%s and %s met %d times, want 1", a, b, n)
+					}
+				}
+				if byes[a] > 1 {
+					t.Errorf("%s had %d byes, want at most 1", a, byes[a])
+				}
+			}
+
+			// nobody moves first in more than one game more than half of its games
+			games := len(tt.seeds) - 1
+			for _, playerID := range tt.seeds {
+				if n := moved[playerID]; 2*n < games-2 || 2*n > games+2 {
+					t.Errorf("%s moved first in %d of %d games", playerID, n, games)
+				}
+			}
+		})
+	}
+}
+
+func TestBracketOrder(t *testing.T) {
+	tests := []struct {
+		size int
+		want []int
+	}{
+		{size: 1, want: []int{1}},
+		{size: 2, want: []int{1, 2}},
+		{size: 4, want: []int{1, 4, 2, 3}},
+		{size: 8, want: []int{1, 8, 4, 5, 2, 7, 3, 6}},
+	}
+
+	for _, tt := range tests {
+		if got := bracketOrder(tt.size); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bracketOrder(%d) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestEliminationPairings(t *testing.T) {
+	tests := []struct {
+		name  string
+		seeds []string
+		want  [][2]string
+	}{
+		{
+			name:  "two players",
+			seeds: []string{"a", "b"},
+			want:  [][2]string{{"a", "b"}},
+		},
+		{
+			name:  "four players",
+			seeds: []string{"a", "b", "c", "d"},
+			want:  [][2]string{{"a", "d"}, {"b", "c"}},
+		},
+		{
+			name:  "top seeds get the byes",
+			seeds: []string{"a", "b", "c", "d", "e"},
+			want:  [][2]string{{"a", ""}, {"d", "e"}, {"b", ""}, {"c", ""}},
+		},
+		{
+			name:  "eight players",
+			seeds: []string{"a", "b", "c", "d", "e", "f", "g", "h"},
+			want:  [][2]string{{"a", "h"}, {"d", "e"}, {"b", "g"}, {"c", "f"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairings := eliminationPairings(tt.seeds)
+			got := make([][2]string, 0, len(pairings))
+			for i, pr := range pairings {
+				got = append(got, [2]string{pr.First, pr.Second})
+				// a bye goes through straight away
+				if pr.Second == "" && (pr.State != pairingStateDone || pr.Winner != pr.First) {
+					t.Errorf("pairing %d = %+v, want a bye won by %s", i+1, pr, pr.First)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("eliminationPairings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ultimate moves are stored as ultimate-<board>-<cell> with sub-boards and their cells numbered 1 to 9 in
+	// reading order e.g ultimate-5-3 (top right cell of the centre sub-board)
+	ultimateMovePrefix = "ultimate-"
+	// sub-boards and cells per sub-board
+	ultimateCells = 9
+	// the board size and win length recorded for ultimate games; sub-boards and the meta-board are 3x3
+	ultimateBoardSize = 9
+	ultimateWinLength = 3
+
+	// result of a sub-board that filled up without a winner
+	subBoardDrawn = "DRAW"
+
+	moveErrorWrongBoard = "WRONG_BOARD"
+)
+
+// lines of a 3x3 grid by cell index in reading order
+var gridLines = [][3]int{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}, {0, 3, 6}, {1, 4, 7}, {2, 5, 8}, {0, 4, 8}, {2, 4, 6}}
+
+// ultimateMove is the payload of a PLAYERMOVE in ultimate tic-tac-toe.
+// Example payload: PLAYERMOVE {"Board": 5, "Cell": 3}
+type ultimateMove struct {
+	Board int
+	Cell  int
+}
+
+// ultimateState is a snapshot of an ultimate board as seen by one of the players
+type ultimateState struct {
+	Mark   string
+	Turn   string
+	Moves  int
+	Over   bool
+	Winner string
+	// sub-board the next move must be played in, numbered 1 to 9; 0 if it may be played in any open sub-board
+	Next int
+	// winner of each sub-board, DRAW for a sub-board that filled up without one or empty while it is open
+	Boards [ultimateCells]string
+	// cells of each sub-board in reading order
+	Cells [ultimateCells][ultimateCells]string
+}
+
+// ultimateBoard is ultimate tic-tac-toe: nine sub-boards laid out as a meta-board. The cell a player takes
+// decides the sub-board the opponent plays in next, unless that sub-board is decided. Winning three sub-boards
+// in a row wins the game
+type ultimateBoard struct {
+	mu     sync.Mutex // guards all fields below
+	cells  [ultimateCells][ultimateCells]string
+	boards [ultimateCells]string
+	// index of the sub-board the next move must be played in or -1 for any
+	next   int
+	turn   string
+	moves  int
+	winner string
+}
+
+func newUltimateBoard() *ultimateBoard {
+	return &ultimateBoard{next: -1, turn: markX}
+}
+
+func formatUltimateMoveID(board, cell int) string {
+	return fmt.Sprintf("%s%d-%d", ultimateMovePrefix, board+1, cell+1)
+}
+
+// parseUltimateMoveID returns the indexes of the sub-board and cell of a move
+func parseUltimateMoveID(moveID string) (int, int, error) {
+	ss := strings.Split(strings.TrimPrefix(moveID, ultimateMovePrefix), "-")
+	if !strings.HasPrefix(moveID, ultimateMovePrefix) || len(ss) != 2 {
+		return 0, 0, newMoveError(moveErrorMalformed, moveID, `expected move of the form {"Board": 5, "Cell": 3}`)
+	}
+	board, err := strconv.Atoi(ss[0])
+	if err != nil {
+		return 0, 0, newMoveError(moveErrorMalformed, moveID, "board is not a number")
+	}
+	cell, err := strconv.Atoi(ss[1])
+	if err != nil {
+		return 0, 0, newMoveError(moveErrorMalformed, moveID, "cell is not a number")
+	}
+	if board < 1 || board > ultimateCells || cell < 1 || cell > ultimateCells {
+		return 0, 0, newMoveError(
+			moveErrorOutOfRange, moveID, fmt.Sprintf("board and cell must be between 1 and %d", ultimateCells),
+		)
+	}
+	return board - 1, cell - 1, nil
+}
+
+// ParseMove reads a structured move, or its move id, into a move id
+func (b *ultimateBoard) ParseMove(payload interface{}) (string, error) {
+	if moveID, ok := payload.(string); ok {
+		_, _, err := parseUltimateMoveID(moveID)
+		return moveID, err
+	}
+	move := &ultimateMove{}
+	err := decodePayload(payload, move)
+	if err != nil {
+		return "", newMoveError(moveErrorMalformed, fmt.Sprint(payload), `expected move of the form {"Board": 5, "Cell": 3}`)
+	}
+	moveID := fmt.Sprintf("%s%d-%d", ultimateMovePrefix, move.Board, move.Cell)
+	_, _, err = parseUltimateMoveID(moveID)
+	return moveID, err
+}
+
+// MovePayload returns the structured move
+func (b *ultimateBoard) MovePayload(moveID string) interface{} {
+	board, cell, err := parseUltimateMoveID(moveID)
+	if err != nil {
+		return moveID
+	}
+	return &ultimateMove{Board: board + 1, Cell: cell + 1}
+}
+
+// Check validates that mark can play moveID without changing the board
+func (b *ultimateBoard) Check(mark, moveID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, _, err := b.check(mark, moveID)
+	return err
+}
+
+func (b *ultimateBoard) check(mark, moveID string) (int, int, error) {
+	board, cell, err := parseUltimateMoveID(moveID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if b.over() {
+		return 0, 0, newMoveError(moveErrorGameOver, moveID, "game is already over")
+	}
+	if b.turn != mark {
+		return 0, 0, newMoveError(moveErrorNotYourTurn, moveID, "it is not your turn")
+	}
+	if b.boards[board] != markNone {
+		return 0, 0, newMoveError(moveErrorWrongBoard, moveID, "sub-board is already decided")
+	}
+	if b.next >= 0 && board != b.next {
+		return 0, 0, newMoveError(moveErrorWrongBoard, moveID, fmt.Sprintf("you must play in sub-board %d", b.next+1))
+	}
+	if b.cells[board][cell] != markNone {
+		return 0, 0, newMoveError(moveErrorCellTaken, moveID, "cell is already taken")
+	}
+	return board, cell, nil
+}
+
+func (b *ultimateBoard) apply(mark string, board, cell int) {
+	b.cells[board][cell] = mark
+	b.turn = opponentMark(mark)
+	b.moves++
+
+	if completesGridLine(b.cells[board], cell, mark) {
+		b.boards[board] = mark
+		if completesGridLine(b.boards, board, mark) {
+			b.winner = mark
+		}
+	} else if gridFull(b.cells[board]) {
+		b.boards[board] = subBoardDrawn
+	}
+
+	// the opponent plays in the sub-board matching the cell unless it is decided
+	b.next = cell
+	if b.boards[cell] != markNone {
+		b.next = -1
+	}
+}
+
+// completesGridLine reports whether a line of a 3x3 grid through index i is filled with mark
+func completesGridLine(grid [ultimateCells]string, i int, mark string) bool {
+	for _, line := range gridLines {
+		if line[0] != i && line[1] != i && line[2] != i {
+			continue
+		}
+		if grid[line[0]] == mark && grid[line[1]] == mark && grid[line[2]] == mark {
+			return true
+		}
+	}
+	return false
+}
+
+func gridFull(grid [ultimateCells]string) bool {
+	for _, cell := range grid {
+		if cell == markNone {
+			return false
+		}
+	}
+	return true
+}
+
+// over reports whether the meta-board has been won or every sub-board is decided
+func (b *ultimateBoard) over() bool {
+	return b.winner != markNone || gridFull(b.boards)
+}
+
+// Play validates and applies moveID for mark
+func (b *ultimateBoard) Play(mark, moveID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	board, cell, err := b.check(mark, moveID)
+	if err != nil {
+		return err
+	}
+	b.apply(mark, board, cell)
+	return nil
+}
+
+// Replay plays moves in order starting with X
+func (b *ultimateBoard) Replay(moves []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, moveID := range moves {
+		board, cell, err := b.check(b.turn, moveID)
+		if err != nil {
+			return err
+		}
+		b.apply(b.turn, board, cell)
+	}
+	return nil
+}
+
+// Result returns the winning mark, if any, and whether the game is over. A finished game without winner is a draw
+func (b *ultimateBoard) Result() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.winner, b.over()
+}
+
+// Turn returns the mark that plays next
+func (b *ultimateBoard) Turn() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.turn
+}
+
+// Moves returns the number of moves played
+func (b *ultimateBoard) Moves() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.moves
+}
+
+// State returns a snapshot of the board for the player with the given mark
+func (b *ultimateBoard) State(mark string) interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &ultimateState{
+		Mark:   mark,
+		Turn:   b.turn,
+		Moves:  b.moves,
+		Over:   b.over(),
+		Winner: b.winner,
+		Next:   b.next + 1,
+		Boards: b.boards,
+		Cells:  b.cells,
+	}
+}
+
+// restore returns a board with the cells and turn of the snapshot
+func (s *ultimateState) restore() (gameBoard, string) {
+	return &ultimateBoard{
+		cells:  s.Cells,
+		boards: s.Boards,
+		next:   s.Next - 1,
+		turn:   s.Turn,
+		moves:  s.Moves,
+		winner: s.Winner,
+	}, s.Mark
+}
+
+// Clone returns a copy of the board
+func (b *ultimateBoard) Clone() gameBoard {
+	return b.clone()
+}
+
+func (b *ultimateBoard) clone() *ultimateBoard {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &ultimateBoard{
+		cells:  b.cells,
+		boards: b.boards,
+		next:   b.next,
+		turn:   b.turn,
+		moves:  b.moves,
+		winner: b.winner,
+	}
+}
+
+// LegalMoves returns the ids of the moves the player whose turn it is may play
+func (b *ultimateBoard) LegalMoves() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.legal()
+}
+
+// BotMove returns the move a built-in bot of the given level plays with mark
+func (b *ultimateBoard) BotMove(mark, level string, rnd *rand.Rand) string {
+	return chooseUltimateMove(b, mark, level, rnd)
+}
+
+func (b *ultimateBoard) legal() []string {
+	moves := make([]string, 0)
+	if b.over() {
+		return moves
+	}
+	for board := 0; board < ultimateCells; board++ {
+		if b.boards[board] != markNone || (b.next >= 0 && board != b.next) {
+			continue
+		}
+		for cell := 0; cell < ultimateCells; cell++ {
+			if b.cells[board][cell] == markNone {
+				moves = append(moves, formatUltimateMoveID(board, cell))
+			}
+		}
+	}
+	return moves
+}
+
+// chooseUltimateMove returns the move id the bot plays with mark. Bots above easy win the game when they can,
+// never hand the opponent a winning move if they can help it and otherwise prefer taking a sub-board
+func chooseUltimateMove(b *ultimateBoard, mark, level string, rnd *rand.Rand) string {
+	moves := b.legal()
+	rnd.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+	if level == botEasy {
+		return moves[0]
+	}
+
+	safe := make([]string, 0, len(moves))
+	for _, moveID := range moves {
+		next := b.clone()
+		logError(next.Play(mark, moveID))
+		if next.winner == mark {
+			return moveID
+		}
+		if !next.canWin(opponentMark(mark)) {
+			safe = append(safe, moveID)
+		}
+	}
+	if len(safe) == 0 {
+		return moves[0]
+	}
+	for _, moveID := range safe {
+		board, _, _ := parseUltimateMoveID(moveID)
+		next := b.clone()
+		logError(next.Play(mark, moveID))
+		if next.boards[board] == mark {
+			return moveID
+		}
+	}
+	return safe[0]
+}
+
+// canWin reports whether mark, whose turn it is, can win the game with its next move
+func (b *ultimateBoard) canWin(mark string) bool {
+	for _, moveID := range b.legal() {
+		next := b.clone()
+		logError(next.Play(mark, moveID))
+		if next.winner == mark {
+			return true
+		}
+	}
+	return false
+}
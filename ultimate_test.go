@@ -0,0 +1,199 @@
+package main
+
+import (
+	"testing"
+)
+
+// moves in which X wins the top left sub-board on its diagonal and O is then sent back to it
+var ultimateSubBoardWon = []string{
+	"ultimate-1-1", "ultimate-1-2", "ultimate-2-3", "ultimate-3-1", "ultimate-1-5", "ultimate-5-1", "ultimate-1-9",
+	"ultimate-9-1",
+}
+
+func TestUltimatePlay(t *testing.T) {
+	tests := []struct {
+		name   string
+		moves  []string
+		mark   string
+		moveID string
+		code   string
+	}{
+		{
+			name:   "first move anywhere",
+			mark:   markX,
+			moveID: "ultimate-7-3",
+		},
+		{
+			name:   "sub-board matching the last cell",
+			moves:  []string{"ultimate-1-5"},
+			mark:   markO,
+			moveID: "ultimate-5-1",
+		},
+		{
+			name:   "other sub-board than the last cell",
+			moves:  []string{"ultimate-1-5"},
+			mark:   markO,
+			moveID: "ultimate-4-1",
+			code:   moveErrorWrongBoard,
+		},
+		{
+			name:   "any sub-board once the matching one is decided",
+			moves:  ultimateSubBoardWon,
+			mark:   markX,
+			moveID: "ultimate-7-7",
+		},
+		{
+			name:   "decided sub-board",
+			moves:  ultimateSubBoardWon,
+			mark:   markX,
+			moveID: "ultimate-1-3",
+			code:   moveErrorWrongBoard,
+		},
+		{
+			name:   "cell taken",
+			moves:  []string{"ultimate-1-1", "ultimate-1-2", "ultimate-2-1"},
+			mark:   markO,
+			moveID: "ultimate-1-1",
+			code:   moveErrorCellTaken,
+		},
+		{
+			name:   "not your turn",
+			mark:   markO,
+			moveID: "ultimate-5-5",
+			code:   moveErrorNotYourTurn,
+		},
+		{
+			name:   "out of range",
+			mark:   markX,
+			moveID: "ultimate-10-1",
+			code:   moveErrorOutOfRange,
+		},
+		{
+			name:   "malformed",
+			mark:   markX,
+			moveID: "box-11",
+			code:   moveErrorMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newUltimateBoard()
+			err := b.Replay(tt.moves)
+			if err != nil {
+				t.Fatalf("Replay() error = %v", err)
+			}
+			err = b.Play(tt.mark, tt.moveID)
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("Play() error = %v", err)
+				}
+				return
+			}
+			moveErr, ok := err.(*moveError)
+			if !ok || moveErr.Code != tt.code {
+				t.Errorf("Play() error = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestUltimateSubBoards(t *testing.T) {
+	b := newUltimateBoard()
+	err := b.Replay(ultimateSubBoardWon)
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if b.boards[0] != markX {
+		t.Errorf("sub-board 1 = %q, want %q", b.boards[0], markX)
+	}
+	if b.next != -1 {
+		t.Errorf("next sub-board = %d, want any", b.next+1)
+	}
+	if winner, over := b.Result(); winner != markNone || over {
+		t.Errorf("Result() = %q, %v, want no winner yet", winner, over)
+	}
+}
+
+func TestUltimateResult(t *testing.T) {
+	tests := []struct {
+		name string
+		// sub-boards decided before the last move, the cells of the sub-board it is played in and the move
+		boards [ultimateCells]string
+		cells  [ultimateCells]string
+		moveID string
+		board  string
+		winner string
+		over   bool
+	}{
+		{
+			name:   "three sub-boards in a row",
+			boards: [ultimateCells]string{markX, markX},
+			cells:  [ultimateCells]string{markX, markX},
+			moveID: "ultimate-3-3",
+			board:  markX,
+			winner: markX,
+			over:   true,
+		},
+		{
+			name:   "three sub-boards down the diagonal",
+			boards: [ultimateCells]string{0: markX, 8: markX},
+			cells:  [ultimateCells]string{0: markX, 4: markX},
+			moveID: "ultimate-5-9",
+			board:  markX,
+			winner: markX,
+			over:   true,
+		},
+		{
+			name:   "sub-board won without a line of sub-boards",
+			boards: [ultimateCells]string{markX, markO},
+			cells:  [ultimateCells]string{markX, markX},
+			moveID: "ultimate-3-3",
+			board:  markX,
+			winner: markNone,
+		},
+		{
+			name:   "sub-board filled without a line",
+			boards: [ultimateCells]string{markX, markX},
+			cells:  [ultimateCells]string{markX, markO, markX, markX, markO, markO, markO, markX},
+			moveID: "ultimate-3-9",
+			board:  subBoardDrawn,
+			winner: markNone,
+		},
+		{
+			name: "every sub-board decided without a line",
+			boards: [ultimateCells]string{
+				markX, markO, markX, markX, markO, markO, markO, markX,
+			},
+			cells:  [ultimateCells]string{markX, markO, markX, markX, markO, markO, markO, markX},
+			moveID: "ultimate-9-9",
+			board:  subBoardDrawn,
+			winner: markNone,
+			over:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board, _, err := parseUltimateMoveID(tt.moveID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := newUltimateBoard()
+			b.boards = tt.boards
+			b.cells[board] = tt.cells
+			b.next = board
+			err = b.Play(markX, tt.moveID)
+			if err != nil {
+				t.Fatalf("Play() error = %v", err)
+			}
+			if b.boards[board] != tt.board {
+				t.Errorf("sub-board %d = %q, want %q", board+1, b.boards[board], tt.board)
+			}
+			winner, over := b.Result()
+			if winner != tt.winner || over != tt.over {
+				t.Errorf("Result() = %q, %v, want %q, %v", winner, over, tt.winner, tt.over)
+			}
+		})
+	}
+}